@@ -5,7 +5,6 @@ import (
 	"net/http"
 
 	"github.com/qcasey/mdroid/pkg/core"
-	"github.com/rs/zerolog/log"
 )
 
 // handleConnect wrapper for connect
@@ -38,7 +37,7 @@ func handleDisconnectNetwork(w http.ResponseWriter, r *http.Request) {
 func handleDisconnect(w http.ResponseWriter, r *http.Request) {
 	err := Disconnect()
 	if err != nil {
-		log.Error().Err(err).Msg("Disconnect failed")
+		core.Log(r.Context()).Error().Err(err).Msg("Disconnect failed")
 		core.WriteNewResponse(&w, r, core.JSONResponse{Output: "Disconnect failed", OK: false})
 	}
 	core.WriteNewResponse(&w, r, core.JSONResponse{Output: "OK", OK: true})
@@ -53,12 +52,12 @@ func handleGetDeviceInfo(w http.ResponseWriter, r *http.Request) {
 func handleGetMediaInfo(w http.ResponseWriter, r *http.Request) {
 	resp, err := GetMetadata()
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to handle media info")
+		core.Log(r.Context()).Error().Err(err).Msg("Failed to handle media info")
 		core.WriteNewResponse(&w, r, core.JSONResponse{Output: fmt.Sprintf("Error getting media info: %s", err.Error()), Status: "fail", OK: false})
 		return
 	}
 
-	log.Info().Msgf("%v", resp)
+	core.Log(r.Context()).Info().Msgf("%v", resp)
 
 	// Echo back all info
 	core.WriteNewResponse(&w, r, core.JSONResponse{Output: resp, Status: "success", OK: true})
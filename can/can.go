@@ -3,6 +3,7 @@ package can
 import (
 	"fmt"
 	logger "log"
+	"strings"
 
 	"github.com/brutella/can"
 	"github.com/qcasey/mdroid/pkg/core"
@@ -13,6 +14,8 @@ import (
 
 var (
 	canLog *logger.Logger
+	// canInterface names the interface in log lines
+	canInterface string
 )
 
 // Start will set up the serial port and ReadSerial goroutine
@@ -28,7 +31,27 @@ func Start(srv *server.Server) {
 		return
 	}
 
+	dbcFiles := []string{builtinDBC}
+	if core.Settings.Store.IsSet("can.dbc") {
+		dbcFiles = core.Settings.Store.GetStringSlice("can.dbc")
+	}
+	var err error
+	database, err = loadDatabase(dbcFiles)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load can DBC files")
+		return
+	}
+	log.Info().Msgf("Loaded %d can messages from %s", len(database), strings.Join(dbcFiles, ", "))
+	topics = resolveTopics(database)
+
+	srv.Router.HandleFunc("/can/send", HandleSend).Methods("POST")
+	srv.Router.HandleFunc("/can/obd/{pid}", HandleOBD).Methods("GET")
+
 	go Connect()
+	go startPeriodic()
+	if core.Settings.Store.GetBool("can.obd.enabled") {
+		go pollOBD()
+	}
 
 	go func() {
 		// Setup channels
@@ -51,18 +74,32 @@ func Start(srv *server.Server) {
 func Connect() {
 	devicePath := core.Settings.Store.GetString("can.device")
 	log.Info().Msgf("Opening CAN device %s...", devicePath)
-	bus, err := can.NewBusForInterfaceWithName(devicePath)
+	if isReplay(devicePath) {
+		// Replayed frames are already logged, and there's no bus to send on
+		replay(strings.TrimPrefix(devicePath, replayPrefix))
+		return
+	}
+
+	newBus, err := can.NewBusForInterfaceWithName(devicePath)
 	if err != nil {
 		log.Error().Err(err).Msgf("Failed to set up can with device %s", devicePath)
 		return
 	}
-	bus.SubscribeFunc(handleCANFrame)
+	newBus.SubscribeFunc(handleCANFrame)
 
 	// Set up file logging
+	canInterface = devicePath
 	canLog = logfile.NewLogFile("/var/log/mdroid/can/")
+	if canLog != nil {
+		// Timestamps are part of the candump format
+		canLog.SetFlags(0)
+	}
 	log.Info().Msgf("Successfully started %s", devicePath)
 
-	err = bus.ConnectAndPublish()
+	// Sending is possible until the bus stops publishing
+	setBus(newBus)
+	err = newBus.ConnectAndPublish()
+	clearBus(newBus)
 	if err != nil {
 		log.Error().Err(err).Msgf("Failed to set up can with device %s", devicePath)
 		return
@@ -0,0 +1,109 @@
+package can
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brutella/can"
+)
+
+const (
+	// remoteFlag marks a remote request frame, which asks for data rather than carrying it
+	remoteFlag = 0x40000000
+	// errorFlag marks an error frame reported by the interface
+	errorFlag = 0x20000000
+	// idMask strips the extended, remote request and error flags from a frame ID
+	idMask = maxExtendedID
+)
+
+// formatCandump writes a frame as a line of a can-utils `candump -l` log, i.e. "(1600000000.123456) can0 1F8#0011223344"
+func formatCandump(t time.Time, iface string, frm can.Frame) string {
+	length := int(frm.Length)
+	if length > len(frm.Data) {
+		length = len(frm.Data)
+	}
+
+	// Extended IDs are written with all 8 digits, which is how candump tells them apart.
+	// Error frames keep their flag, as candump writes them
+	id := fmt.Sprintf("%03X", frm.ID&idMask)
+	switch {
+	case isErrorFrame(frm):
+		id = fmt.Sprintf("%08X", frm.ID&(errorFlag|idMask))
+	case frm.ID&extendedIDFlag != 0 || frm.ID&idMask > maxStandardID:
+		id = fmt.Sprintf("%08X", frm.ID&idMask)
+	}
+	prefix := fmt.Sprintf("(%d.%06d) %s %s#", t.Unix(), t.Nanosecond()/1000, iface, id)
+	if frm.ID&remoteFlag != 0 {
+		return prefix + "R"
+	}
+	return fmt.Sprintf("%s%X", prefix, frm.Data[:length])
+}
+
+// parseCandump reads a line of a `candump -l` log
+func parseCandump(line string) (time.Time, can.Frame, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !strings.HasPrefix(fields[0], "(") || !strings.HasSuffix(fields[0], ")") {
+		return time.Time{}, can.Frame{}, fmt.Errorf("%s is not a candump log line", line)
+	}
+
+	timestamp := strings.Trim(fields[0], "()")
+	parts := strings.SplitN(timestamp, ".", 2)
+	sec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, can.Frame{}, fmt.Errorf("invalid timestamp %s", timestamp)
+	}
+	var usec int64
+	if len(parts) == 2 {
+		// candump writes microseconds, pad anything shorter
+		fraction := (parts[1] + "000000")[:6]
+		if usec, err = strconv.ParseInt(fraction, 10, 64); err != nil {
+			return time.Time{}, can.Frame{}, fmt.Errorf("invalid timestamp %s", timestamp)
+		}
+	}
+
+	frame := strings.SplitN(fields[2], "#", 2)
+	if len(frame) != 2 || strings.HasPrefix(frame[1], "#") {
+		return time.Time{}, can.Frame{}, fmt.Errorf("unsupported frame %s", fields[2])
+	}
+	id, err := strconv.ParseUint(frame[0], 16, 32)
+	if err != nil {
+		return time.Time{}, can.Frame{}, fmt.Errorf("invalid ID %s", frame[0])
+	}
+	// Remote requests carry no data
+	data := frame[1]
+	remote := strings.HasPrefix(data, "R")
+	if remote {
+		data = ""
+	}
+	payload, err := hex.DecodeString(data)
+	if err != nil {
+		return time.Time{}, can.Frame{}, fmt.Errorf("invalid data %s", frame[1])
+	}
+
+	// Error frames carry their error class in the ID, rather than an address newFrame would accept
+	if len(frame[0]) == 8 && id&errorFlag != 0 {
+		if len(payload) > len(can.Frame{}.Data) {
+			return time.Time{}, can.Frame{}, fmt.Errorf("error frame %s carries too much data", fields[2])
+		}
+		frm := can.Frame{ID: uint32(id) & (errorFlag | idMask), Length: uint8(len(payload))}
+		copy(frm.Data[:], payload)
+		return time.Unix(sec, usec*1000), frm, nil
+	}
+
+	frm, err := newFrame(uint32(id), len(frame[0]) == 8, payload)
+	if err != nil {
+		return time.Time{}, can.Frame{}, err
+	}
+	if remote {
+		frm.ID |= remoteFlag
+	}
+	return time.Unix(sec, usec*1000), frm, nil
+}
+
+// isErrorFrame determines if a frame is an error reported by the interface, rather than traffic on the bus
+func isErrorFrame(frm can.Frame) bool {
+	return frm.ID&errorFlag != 0
+}
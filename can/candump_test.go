@@ -0,0 +1,113 @@
+package can
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/qcasey/mdroid/pkg/core"
+)
+
+func TestCandumpRoundTrip(t *testing.T) {
+	timestamp := time.Unix(1600000000, 123456000)
+
+	tests := []struct {
+		name string
+		frm  can.Frame
+		line string
+	}{
+		{"standard", can.Frame{ID: 0x1F8, Length: 5, Data: [8]uint8{0x00, 0x11, 0x22, 0x33, 0x44}}, "(1600000000.123456) can0 1F8#0011223344"},
+		{"short ID", can.Frame{ID: 0x7, Length: 1, Data: [8]uint8{0xFF}}, "(1600000000.123456) can0 007#FF"},
+		{"empty", can.Frame{ID: 0x100}, "(1600000000.123456) can0 100#"},
+		{"extended", can.Frame{ID: 0x18DB33F1 | extendedIDFlag, Length: 2, Data: [8]uint8{0x02, 0x01}}, "(1600000000.123456) can0 18DB33F1#0201"},
+		{"extended small ID", can.Frame{ID: 0x123 | extendedIDFlag, Length: 1, Data: [8]uint8{0x01}}, "(1600000000.123456) can0 00000123#01"},
+		{"remote request", can.Frame{ID: 0x123 | remoteFlag}, "(1600000000.123456) can0 123#R"},
+		{"extended remote request", can.Frame{ID: 0x18DB33F1 | extendedIDFlag | remoteFlag}, "(1600000000.123456) can0 18DB33F1#R"},
+		{"error frame", can.Frame{ID: 0x004 | errorFlag, Length: 8, Data: [8]uint8{0x00, 0x04}}, "(1600000000.123456) can0 20000004#0004000000000000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := formatCandump(timestamp, "can0", tt.frm)
+			if line != tt.line {
+				t.Fatalf("formatCandump() = %q, want %q", line, tt.line)
+			}
+
+			parsed, frm, err := parseCandump(line)
+			if err != nil {
+				t.Fatalf("failed to parse %q: %s", line, err.Error())
+			}
+			if !parsed.Equal(timestamp) {
+				t.Errorf("parsed timestamp %s, want %s", parsed, timestamp)
+			}
+			if frm != tt.frm {
+				t.Errorf("parsed %+v, want %+v", frm, tt.frm)
+			}
+		})
+	}
+}
+
+func TestParseCandumpErrors(t *testing.T) {
+	for _, line := range []string{
+		"1F8#00",
+		"(1600000000.123456) can0",
+		"(16000x0000.123456) can0 1F8#00",
+		"(1600000000.123456) can0 1F8",
+		"(1600000000.123456) can0 1F8##00",
+		"(1600000000.123456) can0 XYZ#00",
+		"(1600000000.123456) can0 1F8#0",
+		"(1600000000.123456) can0 1F8#001122334455667788",
+		"(1600000000.123456) can0 20000004#001122334455667788",
+	} {
+		if _, _, err := parseCandump(line); err == nil {
+			t.Errorf("parsed %q without an error", line)
+		}
+	}
+}
+
+func TestReplayFile(t *testing.T) {
+	core.Settings = core.NewDatastore(false)
+	core.Settings.Store.Set("can.replay_loop", true)
+
+	dir, err := ioutil.TempDir("", "can")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	capture := filepath.Join(dir, "capture.log")
+	ioutil.WriteFile(capture, []byte("(1600000000.000000) can0 1F8#00\nnot a frame\n\n(1600000000.050000) can0 20000004#0004000000000000\n(1600000000.100000) can0 1F8#01\n"), 0644)
+	if frames, err := replayFile(capture, 0); err != nil || frames != 2 {
+		t.Errorf("replayFile() = %d, %v, want 2 frames", frames, err)
+	}
+
+	if _, err := replayFile(filepath.Join(dir, "missing.log"), 0); err == nil {
+		t.Error("replayed a missing log without an error")
+	}
+
+	// Error frames aren't replayed as traffic
+	errorLog := filepath.Join(dir, "errors.log")
+	ioutil.WriteFile(errorLog, []byte("(1600000000.000000) can0 20000004#0004000000000000\n"), 0644)
+	if frames, err := replayFile(errorLog, 0); err != nil || frames != 0 {
+		t.Errorf("replayFile() = %d, %v for a log of error frames, want 0 frames", frames, err)
+	}
+
+	// Looping over logs without frames would spin, so replay gives up on them
+	empty := filepath.Join(dir, "empty.log")
+	ioutil.WriteFile(empty, []byte("not a frame\n"), 0644)
+	for _, path := range []string{empty, errorLog, filepath.Join(dir, "missing.log")} {
+		done := make(chan struct{})
+		go func() {
+			replay(path)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("replaying %s kept looping", path)
+		}
+	}
+}
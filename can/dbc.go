@@ -0,0 +1,290 @@
+package can
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed" // E46 database
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/brutella/can"
+)
+
+//go:embed e46.dbc
+var e46DBC []byte
+
+// builtinDBC names the shipped E46 database in can.dbc
+const builtinDBC = "e46"
+
+// extendedIDFlag marks an extended frame ID, both in DBC files and in frames
+const extendedIDFlag = 0x80000000
+
+var (
+	messagePattern = regexp.MustCompile(`^BO_\s+(\d+)\s+(\w+)\s*:\s*(\d+)`)
+	signalPattern  = regexp.MustCompile(`^SG_\s+(\w+)\s*(M|m\d+)?\s*:\s*(\d+)\|(\d+)@([01])([+-])\s*\(([^,]+),([^)]+)\)\s*\[[^\]]*\]\s*"([^"]*)"`)
+	valuesPattern  = regexp.MustCompile(`^VAL_\s+(\d+)\s+(\w+)\s+(.*);`)
+	valuePattern   = regexp.MustCompile(`(-?\d+)\s+"([^"]*)"`)
+)
+
+// Message is a CAN frame defined in a DBC file
+type Message struct {
+	ID      uint32
+	Name    string
+	Signals []*Signal
+	// multiplexor is the signal choosing which multiplexed signals are present
+	multiplexor *Signal
+}
+
+// Signal is a value packed into the bits of a message
+type Signal struct {
+	Name      string
+	Start     int
+	Length    int
+	BigEndian bool
+	Signed    bool
+	Factor    float64
+	Offset    float64
+	Unit      string
+	// Values label raw values, i.e. 2 "ON"
+	Values map[int64]string
+
+	isMultiplexor bool
+	// multiplexed signals are only present when the multiplexor holds this value
+	multiplexed bool
+	mux         int64
+}
+
+// Database holds every message from the loaded DBC files, by frame ID
+type Database map[uint32]*Message
+
+// loadDatabase reads each DBC file in order, where later files replace earlier messages with the same ID
+func loadDatabase(paths []string) (Database, error) {
+	db := make(Database)
+	for _, path := range paths {
+		var contents []byte
+		var err error
+		if path == builtinDBC {
+			contents = e46DBC
+		} else if contents, err = ioutil.ReadFile(path); err != nil {
+			return nil, err
+		}
+
+		messages, err := parseDBC(contents)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DBC %s: %s", path, err.Error())
+		}
+		for id, m := range messages {
+			db[id] = m
+		}
+	}
+	return db, nil
+}
+
+// parseDBC reads the messages, signals and value tables of a DBC file, ignoring everything else
+func parseDBC(contents []byte) (Database, error) {
+	db := make(Database)
+	var current *Message
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case strings.HasPrefix(text, "BO_ "):
+			match := messagePattern.FindStringSubmatch(text)
+			if match == nil {
+				return nil, fmt.Errorf("line %d: invalid message", line)
+			}
+			id, err := strconv.ParseUint(match[1], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid message ID %s", line, match[1])
+			}
+			current = &Message{ID: uint32(id), Name: match[2]}
+			db[current.ID] = current
+
+		case strings.HasPrefix(text, "SG_ "):
+			if current == nil {
+				return nil, fmt.Errorf("line %d: signal outside of a message", line)
+			}
+			s, err := parseSignal(text)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", line, err.Error())
+			}
+			current.Signals = append(current.Signals, s)
+			if s.isMultiplexor {
+				current.multiplexor = s
+			}
+
+		case strings.HasPrefix(text, "VAL_ "):
+			if err := parseValues(db, text); err != nil {
+				return nil, fmt.Errorf("line %d: %s", line, err.Error())
+			}
+
+		case text == "":
+			current = nil
+		}
+	}
+	return db, scanner.Err()
+}
+
+func parseSignal(text string) (*Signal, error) {
+	match := signalPattern.FindStringSubmatch(text)
+	if match == nil {
+		return nil, fmt.Errorf("invalid signal")
+	}
+
+	s := &Signal{
+		Name:      match[1],
+		BigEndian: match[5] == "0",
+		Signed:    match[6] == "-",
+		Unit:      match[9],
+	}
+	s.Start, _ = strconv.Atoi(match[3])
+	s.Length, _ = strconv.Atoi(match[4])
+	if s.Length < 1 || s.Length > 64 {
+		return nil, fmt.Errorf("signal %s has invalid length %d", s.Name, s.Length)
+	}
+
+	var err error
+	if s.Factor, err = strconv.ParseFloat(strings.TrimSpace(match[7]), 64); err != nil {
+		return nil, fmt.Errorf("signal %s has invalid factor %s", s.Name, match[7])
+	}
+	if s.Offset, err = strconv.ParseFloat(strings.TrimSpace(match[8]), 64); err != nil {
+		return nil, fmt.Errorf("signal %s has invalid offset %s", s.Name, match[8])
+	}
+
+	switch {
+	case match[2] == "M":
+		s.isMultiplexor = true
+	case match[2] != "":
+		s.multiplexed = true
+		s.mux, _ = strconv.ParseInt(match[2][1:], 10, 64)
+	}
+	return s, nil
+}
+
+// parseValues adds a value table to the signal it names
+func parseValues(db Database, text string) error {
+	match := valuesPattern.FindStringSubmatch(text)
+	if match == nil {
+		return fmt.Errorf("invalid value table")
+	}
+	id, _ := strconv.ParseUint(match[1], 10, 32)
+	m, ok := db[uint32(id)]
+	if !ok {
+		return fmt.Errorf("value table for unknown message %s", match[1])
+	}
+
+	for _, s := range m.Signals {
+		if s.Name != match[2] {
+			continue
+		}
+		s.Values = make(map[int64]string)
+		for _, pair := range valuePattern.FindAllStringSubmatch(match[3], -1) {
+			raw, _ := strconv.ParseInt(pair[1], 10, 64)
+			s.Values[raw] = pair[2]
+		}
+		return nil
+	}
+	return fmt.Errorf("value table for unknown signal %s", match[2])
+}
+
+// frameID is the ID of a frame as written in DBC files, with the extended flag set for extended frames
+func frameID(frm can.Frame) uint32 {
+	if frm.ID > 0x7FF {
+		return frm.ID | extendedIDFlag
+	}
+	return frm.ID
+}
+
+// Decode every signal present in a frame, by signal name
+func (m *Message) Decode(frm can.Frame) map[string]interface{} {
+	length := int(frm.Length)
+	if length > len(frm.Data) {
+		length = len(frm.Data)
+	}
+	data := frm.Data[:length]
+	values := make(map[string]interface{}, len(m.Signals))
+
+	var mux int64
+	if m.multiplexor != nil {
+		raw, ok := m.multiplexor.raw(data)
+		if !ok {
+			return values
+		}
+		mux = raw
+	}
+
+	for _, s := range m.Signals {
+		if s.multiplexed && (m.multiplexor == nil || s.mux != mux) {
+			continue
+		}
+		if v, ok := s.Decode(data); ok {
+			values[s.Name] = v
+		}
+	}
+	return values
+}
+
+// Decode a signal into its labelled value, a bool for single bit flags, or its scaled number
+func (s *Signal) Decode(data []byte) (interface{}, bool) {
+	raw, ok := s.raw(data)
+	if !ok {
+		return nil, false
+	}
+
+	if label, ok := s.Values[raw]; ok {
+		return label, true
+	}
+	if s.Length == 1 && !s.Signed && s.Factor == 1 && s.Offset == 0 {
+		return raw == 1, true
+	}
+	if s.Factor == float64(int64(s.Factor)) && s.Offset == float64(int64(s.Offset)) {
+		return raw*int64(s.Factor) + int64(s.Offset), true
+	}
+	return float64(raw)*s.Factor + s.Offset, true
+}
+
+// raw reads the signal's bits, skipping signals that don't fit in the frame
+func (s *Signal) raw(data []byte) (int64, bool) {
+	bit := func(position int) (uint64, bool) {
+		if position < 0 || position/8 >= len(data) {
+			return 0, false
+		}
+		return uint64(data[position/8]>>(position%8)) & 1, true
+	}
+
+	var value uint64
+	if s.BigEndian {
+		// Motorola signals start at their most significant bit, counting down each byte then on to the next
+		position := s.Start
+		for i := 0; i < s.Length; i++ {
+			b, ok := bit(position)
+			if !ok {
+				return 0, false
+			}
+			value = value<<1 | b
+			if position%8 == 0 {
+				position += 15
+			} else {
+				position--
+			}
+		}
+	} else {
+		for i := 0; i < s.Length; i++ {
+			b, ok := bit(s.Start + i)
+			if !ok {
+				return 0, false
+			}
+			value |= b << uint(i)
+		}
+	}
+
+	if s.Signed && s.Length < 64 && value&(1<<uint(s.Length-1)) != 0 {
+		return int64(value) - int64(1)<<uint(s.Length), true
+	}
+	return int64(value), true
+}
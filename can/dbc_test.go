@@ -0,0 +1,177 @@
+package can
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/brutella/can"
+	"github.com/qcasey/mdroid/pkg/core"
+)
+
+const testDBC = `VERSION ""
+
+BO_ 100 Status: 8 ECU
+ SG_ Flag : 0|1@1+ (1,0) [0|1] "" Vector__XXX
+ SG_ Mode : 8|2@1+ (1,0) [0|3] "" Vector__XXX
+ SG_ Temperature : 16|8@1- (0.5,-10) [-74|53.5] "C" Vector__XXX
+ SG_ Pressure : 31|12@0+ (1,0) [0|4095] "kPa" Vector__XXX
+
+BO_ 2147484160 Extended: 8 ECU
+ SG_ Page M : 0|8@1+ (1,0) [0|255] "" Vector__XXX
+ SG_ Voltage m1 : 8|8@1+ (0.1,0) [0|25.5] "V" Vector__XXX
+ SG_ Current m2 : 8|8@1- (1,0) [-128|127] "A" Vector__XXX
+
+VAL_ 100 Mode 0 "OFF" 1 "ON" 2 "ERROR" ;
+`
+
+func TestParseDBC(t *testing.T) {
+	db, err := parseDBC([]byte(testDBC))
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err.Error())
+	}
+	if len(db) != 2 {
+		t.Fatalf("parsed %d messages, want 2", len(db))
+	}
+
+	status, ok := db[100]
+	if !ok || status.Name != "Status" || len(status.Signals) != 4 {
+		t.Fatalf("parsed message 100 as %+v", status)
+	}
+	want := &Signal{Name: "Temperature", Start: 16, Length: 8, Signed: true, Factor: 0.5, Offset: -10, Unit: "C"}
+	if !reflect.DeepEqual(status.Signals[2], want) {
+		t.Errorf("parsed %+v, want %+v", status.Signals[2], want)
+	}
+	if s := status.Signals[3]; !s.BigEndian || s.Signed || s.Start != 31 || s.Length != 12 {
+		t.Errorf("parsed Motorola signal as %+v", s)
+	}
+	if labels := status.Signals[1].Values; !reflect.DeepEqual(labels, map[int64]string{0: "OFF", 1: "ON", 2: "ERROR"}) {
+		t.Errorf("parsed value table as %v", labels)
+	}
+
+	extended, ok := db[512|extendedIDFlag]
+	if !ok || extended.multiplexor == nil || extended.multiplexor.Name != "Page" {
+		t.Fatalf("parsed extended message as %+v", extended)
+	}
+	if s := extended.Signals[2]; !s.multiplexed || s.mux != 2 {
+		t.Errorf("parsed multiplexed signal as %+v", s)
+	}
+}
+
+func TestParseDBCErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		dbc  string
+	}{
+		{"signal outside a message", ` SG_ Flag : 0|1@1+ (1,0) [0|1] "" Vector__XXX`},
+		{"invalid signal", "BO_ 100 Status: 8 ECU\n SG_ Flag : 0|1@1+ [0|1] \"\" Vector__XXX"},
+		{"invalid length", "BO_ 100 Status: 8 ECU\n SG_ Flag : 0|65@1+ (1,0) [0|1] \"\" Vector__XXX"},
+		{"invalid factor", "BO_ 100 Status: 8 ECU\n SG_ Flag : 0|1@1+ (x,0) [0|1] \"\" Vector__XXX"},
+		{"unknown message values", `VAL_ 100 Mode 0 "OFF" ;`},
+		{"unknown signal values", "BO_ 100 Status: 8 ECU\n SG_ Flag : 0|1@1+ (1,0) [0|1] \"\" Vector__XXX\n\nVAL_ 100 Mode 0 \"OFF\" ;"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseDBC([]byte(tt.dbc)); err == nil {
+				t.Error("parsed without an error")
+			}
+		})
+	}
+}
+
+func TestSignalRaw(t *testing.T) {
+	tests := []struct {
+		name   string
+		signal Signal
+		data   []byte
+		want   int64
+		ok     bool
+	}{
+		{"Intel byte", Signal{Start: 8, Length: 8}, []byte{0x00, 0xAB}, 0xAB, true},
+		{"Intel across bytes", Signal{Start: 12, Length: 12}, []byte{0x00, 0x50, 0x12}, 0x125, true},
+		{"Intel signed", Signal{Start: 24, Length: 8, Signed: true}, []byte{0, 0, 0, 0xF6}, -10, true},
+		{"Intel signed positive", Signal{Start: 24, Length: 8, Signed: true}, []byte{0, 0, 0, 0x0A}, 10, true},
+		{"Motorola word", Signal{Start: 7, Length: 16, BigEndian: true}, []byte{0x12, 0x34}, 0x1234, true},
+		{"Motorola across bytes", Signal{Start: 3, Length: 8, BigEndian: true}, []byte{0x0A, 0xB0}, 0xAB, true},
+		{"Motorola signed", Signal{Start: 7, Length: 12, BigEndian: true, Signed: true}, []byte{0xFF, 0xE0}, -2, true},
+		{"single bit", Signal{Start: 13, Length: 1}, []byte{0x00, 0x20}, 1, true},
+		{"past the end", Signal{Start: 56, Length: 16}, make([]byte, 8), 0, false},
+		{"Motorola past the end", Signal{Start: 7, Length: 16, BigEndian: true}, []byte{0x12}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.signal.raw(tt.data)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("raw(% X) = %d, %t, want %d, %t", tt.data, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDecodeE46(t *testing.T) {
+	db, err := loadDatabase([]string{builtinDBC})
+	if err != nil {
+		t.Fatalf("failed to load the E46 database: %s", err.Error())
+	}
+
+	tests := []struct {
+		name string
+		frm  can.Frame
+		want map[string]interface{}
+	}{
+		{"climate", can.Frame{ID: 1557, Length: 8, Data: [8]uint8{0x80, 0, 0, 0xF6}}, map[string]interface{}{
+			"Air_Conditioning_On":    true,
+			"Exterior_Temperature_C": int64(-10),
+		}},
+		{"speed", can.Frame{ID: 339, Length: 8, Data: [8]uint8{0, 0x00, 0x0C}}, map[string]interface{}{
+			"Speed": 24.0,
+		}},
+		{"short frame", can.Frame{ID: 339, Length: 2, Data: [8]uint8{0, 0x00, 0x0C}}, map[string]interface{}{}},
+		{"sport mode", can.Frame{ID: 824, Length: 8, Data: [8]uint8{0, 0, 3}}, map[string]interface{}{
+			"Sport_Mode": "ERROR",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := db[frameID(tt.frm)].Decode(tt.frm)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	values := map[string]interface{}{"Speed": 0.375, "Sport_Mode": "ON", "Cruise_Control_Buttons": "RESUME"}
+	compatible(values)
+	want := map[string]interface{}{
+		"Speed":                         0.0,
+		"Sport_Mode":                    "ON",
+		"Sport_Mode_On":                 true,
+		"Sport_Mode_Error":              false,
+		"Cruise_Control_Buttons":        "RESUME",
+		"Cruise_Control_Resume_Pressed": true,
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("compatible() = %v, want %v", values, want)
+	}
+}
+
+func TestResolveTopics(t *testing.T) {
+	core.Settings = core.NewDatastore(false)
+	core.Settings.Store.Set("can.topics.rpm", "ENGINE_RPM")
+	core.Settings.Store.Set("can.topics.sport_mode_on", "")
+
+	db, err := loadDatabase([]string{builtinDBC})
+	if err != nil {
+		t.Fatalf("failed to load the E46 database: %s", err.Error())
+	}
+	resolved := resolveTopics(db)
+	for signal, want := range map[string]string{"RPM": "ENGINE_RPM", "Speed": "Speed", "Sport_Mode_On": "", "Sport_Mode_Error": "Sport_Mode_Error"} {
+		if got, ok := resolved[signal]; !ok || got != want {
+			t.Errorf("topic of %s = %q, want %q", signal, got, want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package can
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/rs/zerolog/log"
+)
+
+// ISO-TP (ISO 15765-2) frame types, in the high nibble of the first byte
+const (
+	singleFrame      = 0x0
+	firstFrame       = 0x1
+	consecutiveFrame = 0x2
+	flowControl      = 0x3
+)
+
+const (
+	// obdBroadcastID addresses every emissions ECU at once, the engine answers on obdResponseID
+	obdBroadcastID = 0x7DF
+	obdResponseID  = 0x7E8
+	// requestTimeout bounds requests whose context has no deadline
+	requestTimeout = time.Second
+)
+
+// ErrTimeout is returned when an ECU doesn't answer a request in time
+var ErrTimeout = errors.New("timed out waiting for a can response")
+
+var (
+	// requestLock allows a single request at a time, since replies share their IDs
+	requestLock sync.Mutex
+
+	responses     = make(map[uint32]chan can.Frame)
+	responsesLock sync.Mutex
+)
+
+// responseID is the ID an ECU answers a request on
+func responseID(id uint32) uint32 {
+	if id == obdBroadcastID {
+		return obdResponseID
+	}
+	return id + 8
+}
+
+// Request sends an ISO-TP request to the given ID, returning the ECU's reassembled response.
+// Requests must fit a single frame, responses may span several
+func Request(ctx context.Context, id uint32, payload []byte) ([]byte, error) {
+	if len(payload) == 0 || len(payload) > 7 {
+		return nil, fmt.Errorf("requests must be 1 to 7 bytes, got %d", len(payload))
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
+	}
+
+	requestLock.Lock()
+	defer requestLock.Unlock()
+
+	response := responseID(id)
+	frames := make(chan can.Frame, 16)
+	responsesLock.Lock()
+	responses[response] = frames
+	responsesLock.Unlock()
+	defer func() {
+		responsesLock.Lock()
+		delete(responses, response)
+		responsesLock.Unlock()
+	}()
+
+	if err := Send(ctx, id, false, pad(append([]byte{byte(len(payload))}, payload...))); err != nil {
+		return nil, err
+	}
+
+	// Ask the ECU for the rest of a multi frame response, all at once with no separation time
+	flow := func() error {
+		return Send(ctx, response-8, false, pad([]byte{flowControl << 4, 0x00, 0x00}))
+	}
+	return receive(ctx, frames, flow)
+}
+
+// receive reassembles a response, calling flow to ask for the rest of a multi frame response
+func receive(ctx context.Context, frames chan can.Frame, flow func() error) ([]byte, error) {
+	var (
+		message  []byte
+		length   int
+		sequence byte
+	)
+
+	for {
+		var frm can.Frame
+		select {
+		case frm = <-frames:
+		case <-ctx.Done():
+			return nil, ErrTimeout
+		}
+		if frm.Length == 0 {
+			continue
+		}
+		data := frm.Data[:frm.Length]
+
+		switch data[0] >> 4 {
+		case singleFrame:
+			size := int(data[0] & 0x0F)
+			if size == 0 || size > len(data)-1 {
+				return nil, fmt.Errorf("invalid single frame % X", data)
+			}
+			return data[1 : 1+size], nil
+
+		case firstFrame:
+			if len(data) < 2 {
+				return nil, fmt.Errorf("invalid first frame % X", data)
+			}
+			length = int(data[0]&0x0F)<<8 | int(data[1])
+			message = append([]byte{}, data[2:]...)
+			sequence = 1
+
+			if err := flow(); err != nil {
+				return nil, err
+			}
+
+		case consecutiveFrame:
+			if message == nil {
+				continue
+			}
+			if data[0]&0x0F != sequence {
+				return nil, fmt.Errorf("expected consecutive frame %d, got %d", sequence, data[0]&0x0F)
+			}
+			sequence = (sequence + 1) & 0x0F
+			message = append(message, data[1:]...)
+		}
+
+		if message != nil && len(message) >= length {
+			return message[:length], nil
+		}
+	}
+}
+
+// deliverResponse passes a frame to the request waiting on its ID, if there is one
+func deliverResponse(frm can.Frame) {
+	responsesLock.Lock()
+	frames, ok := responses[frm.ID&idMask]
+	responsesLock.Unlock()
+	if !ok {
+		return
+	}
+
+	select {
+	case frames <- frm:
+	default:
+		log.Warn().Msgf("Dropped can response frame %X, the request isn't keeping up", frm.ID)
+	}
+}
+
+// pad a frame's data to a full 8 bytes, which most ECUs expect
+func pad(data []byte) []byte {
+	padded := make([]byte, 8)
+	copy(padded, data)
+	return padded
+}
@@ -0,0 +1,164 @@
+package can
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/qcasey/mdroid/pkg/core"
+)
+
+// responseFrame is a frame from the engine, padded to 8 bytes like most ECUs send
+func responseFrame(data ...byte) can.Frame {
+	frm := can.Frame{ID: obdResponseID, Length: 8}
+	copy(frm.Data[:], data)
+	return frm
+}
+
+// longResponse is a multi frame response carrying length bytes counting up from 0,
+// along with the frames that send it
+func longResponse(length int) ([]byte, []can.Frame) {
+	message := make([]byte, length)
+	for i := range message {
+		message[i] = byte(i)
+	}
+
+	frames := []can.Frame{responseFrame(append([]byte{firstFrame<<4 | byte(length>>8), byte(length)}, message[:6]...)...)}
+	sequence := byte(1)
+	for sent := 6; sent < length; sent += 7 {
+		end := sent + 7
+		if end > length {
+			end = length
+		}
+		frames = append(frames, responseFrame(append([]byte{consecutiveFrame<<4 | sequence}, message[sent:end]...)...))
+		sequence = (sequence + 1) & 0x0F
+	}
+	return message, frames
+}
+
+func TestReceive(t *testing.T) {
+	vin, vinFrames := longResponse(20)
+	wrapped, wrappedFrames := longResponse(6 + 7*17)
+
+	tests := []struct {
+		name   string
+		frames []can.Frame
+		want   []byte
+		flows  int
+		err    bool
+	}{
+		{"single frame", []can.Frame{responseFrame(0x04, 0x41, 0x0C, 0x1A, 0xF8)}, []byte{0x41, 0x0C, 0x1A, 0xF8}, 0, false},
+		{"empty frames skipped", []can.Frame{{ID: obdResponseID}, responseFrame(0x02, 0x41, 0x00)}, []byte{0x41, 0x00}, 0, false},
+		{"multi frame", vinFrames, vin, 1, false},
+		{"sequence wrap", wrappedFrames, wrapped, 1, false},
+		{"consecutive before first", append([]can.Frame{vinFrames[1]}, vinFrames...), vin, 1, false},
+		{"missed consecutive", []can.Frame{vinFrames[0], vinFrames[2]}, nil, 1, true},
+		{"empty single frame", []can.Frame{responseFrame(0x00)}, nil, 0, true},
+		{"oversized single frame", []can.Frame{{ID: obdResponseID, Length: 3, Data: [8]uint8{0x05, 0x41, 0x00}}}, nil, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames := make(chan can.Frame, len(tt.frames))
+			for _, frm := range tt.frames {
+				frames <- frm
+			}
+			flows := 0
+			flow := func() error {
+				flows++
+				return nil
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			got, err := receive(ctx, frames, flow)
+			if (err != nil) != tt.err {
+				t.Fatalf("receive() error = %v, want error %t", err, tt.err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("receive() = % X, want % X", got, tt.want)
+			}
+			if flows != tt.flows {
+				t.Errorf("sent %d flow control frames, want %d", flows, tt.flows)
+			}
+		})
+	}
+}
+
+func TestReceiveFailures(t *testing.T) {
+	_, frames := longResponse(20)
+
+	// A multi frame response stops once the flow control frame can't be sent
+	sendErr := errors.New("send failed")
+	ch := make(chan can.Frame, len(frames))
+	for _, frm := range frames {
+		ch <- frm
+	}
+	if _, err := receive(context.Background(), ch, func() error { return sendErr }); err != sendErr {
+		t.Errorf("receive() = %v when flow control fails, want %v", err, sendErr)
+	}
+
+	// The rest of a response never arriving times out
+	ch = make(chan can.Frame, 1)
+	ch <- frames[0]
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := receive(ctx, ch, func() error { return nil }); err != ErrTimeout {
+		t.Errorf("receive() = %v without the rest of the response, want ErrTimeout", err)
+	}
+}
+
+func TestDeliverResponse(t *testing.T) {
+	frames := make(chan can.Frame, 1)
+	responsesLock.Lock()
+	responses[obdResponseID] = frames
+	responsesLock.Unlock()
+	defer func() {
+		responsesLock.Lock()
+		delete(responses, obdResponseID)
+		responsesLock.Unlock()
+	}()
+
+	// Frames for other IDs aren't delivered
+	deliverResponse(can.Frame{ID: 0x7E9, Length: 8})
+	if len(frames) != 0 {
+		t.Fatal("delivered a frame for another ID")
+	}
+
+	// Once a slow request's buffer is full, frames are dropped rather than blocking the bus
+	first := responseFrame(0x10, 0x14)
+	deliverResponse(first)
+	deliverResponse(responseFrame(0x21))
+	if len(frames) != 1 {
+		t.Fatalf("buffered %d frames, want 1", len(frames))
+	}
+	if frm := <-frames; frm != first {
+		t.Errorf("delivered %+v, want the first frame %+v", frm, first)
+	}
+
+	// Flags aren't part of the ID frames are delivered on
+	flagged := responseFrame(0x02, 0x41, 0x00)
+	flagged.ID |= extendedIDFlag
+	deliverResponse(flagged)
+	if len(frames) != 1 {
+		t.Error("a flagged frame wasn't delivered")
+	}
+}
+
+func TestIgnitionOff(t *testing.T) {
+	core.Session = core.NewDatastore(false)
+	if IgnitionOff() {
+		t.Error("the ignition is off before it's been reported")
+	}
+	core.Session.Store.Set("ignition", true)
+	if IgnitionOff() {
+		t.Error("the ignition is off while it's on")
+	}
+	core.Session.Store.Set("ignition", false)
+	if !IgnitionOff() {
+		t.Error("the ignition isn't off once it's been turned off")
+	}
+}
@@ -0,0 +1,149 @@
+package can
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/qcasey/mdroid/pkg/core"
+	"github.com/rs/zerolog/log"
+)
+
+// PID is an OBD-II mode 01 parameter, decoded from the bytes after its echoed PID
+type PID struct {
+	Code   byte
+	Length int
+	Decode func(b []byte) interface{}
+}
+
+const defaultOBDInterval = 2 * time.Second
+
+// defaultPIDs are polled when can.obd.pids isn't set
+var defaultPIDs = []string{"maf", "short_fuel_trim_1", "long_fuel_trim_1", "short_fuel_trim_2", "long_fuel_trim_2", "intake_temp", "o2_b1s1", "o2_b2s1"}
+
+// pids are the standard mode 01 parameters, by the name they're published under as obd.<name>
+var pids = map[string]PID{
+	"engine_load":            {0x04, 1, percent},
+	"coolant_temp":           {0x05, 1, temperature},
+	"short_fuel_trim_1":      {0x06, 1, fuelTrim},
+	"long_fuel_trim_1":       {0x07, 1, fuelTrim},
+	"short_fuel_trim_2":      {0x08, 1, fuelTrim},
+	"long_fuel_trim_2":       {0x09, 1, fuelTrim},
+	"intake_pressure":        {0x0B, 1, whole},
+	"rpm":                    {0x0C, 2, func(b []byte) interface{} { return float64(word(b)) / 4 }},
+	"speed":                  {0x0D, 1, whole},
+	"timing_advance":         {0x0E, 1, func(b []byte) interface{} { return float64(b[0])/2 - 64 }},
+	"intake_temp":            {0x0F, 1, temperature},
+	"maf":                    {0x10, 2, func(b []byte) interface{} { return float64(word(b)) / 100 }},
+	"throttle":               {0x11, 1, percent},
+	"o2_b1s1":                {0x14, 2, oxygenSensor},
+	"o2_b1s2":                {0x15, 2, oxygenSensor},
+	"o2_b1s3":                {0x16, 2, oxygenSensor},
+	"o2_b1s4":                {0x17, 2, oxygenSensor},
+	"o2_b2s1":                {0x18, 2, oxygenSensor},
+	"o2_b2s2":                {0x19, 2, oxygenSensor},
+	"o2_b2s3":                {0x1A, 2, oxygenSensor},
+	"o2_b2s4":                {0x1B, 2, oxygenSensor},
+	"run_time":               {0x1F, 2, func(b []byte) interface{} { return word(b) }},
+	"fuel_level":             {0x2F, 1, percent},
+	"barometric_pressure":    {0x33, 1, whole},
+	"control_module_voltage": {0x42, 2, func(b []byte) interface{} { return float64(word(b)) / 1000 }},
+	"ambient_temp":           {0x46, 1, temperature},
+	"oil_temp":               {0x5C, 1, temperature},
+}
+
+func whole(b []byte) interface{}       { return int(b[0]) }
+func word(b []byte) int                { return int(b[0])<<8 | int(b[1]) }
+func percent(b []byte) interface{}     { return float64(b[0]) * 100 / 255 }
+func temperature(b []byte) interface{} { return int(b[0]) - 40 }
+func fuelTrim(b []byte) interface{}    { return float64(b[0])*100/128 - 100 }
+
+// oxygenSensor is the sensor's voltage, the second byte is its fuel trim
+func oxygenSensor(b []byte) interface{} { return float64(b[0]) / 200 }
+
+// QueryPID requests a single mode 01 PID from the engine
+func QueryPID(ctx context.Context, name string) (interface{}, error) {
+	pid, ok := pids[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown OBD PID %s", name)
+	}
+
+	response, err := Request(ctx, obdBroadcastID, []byte{0x01, pid.Code})
+	if err != nil {
+		return nil, err
+	}
+	if len(response) < 2+pid.Length || response[0] != 0x41 || response[1] != pid.Code {
+		return nil, fmt.Errorf("unexpected response to PID %02X: % X", pid.Code, response)
+	}
+	return pid.Decode(response[2 : 2+pid.Length]), nil
+}
+
+// obdInterval is how often a PID is polled, from can.obd.intervals.<name> or can.obd.interval
+func obdInterval(name string) time.Duration {
+	key := fmt.Sprintf("can.obd.intervals.%s", name)
+	if core.Settings.Store.IsSet(key) {
+		return core.Settings.Store.GetDuration(key)
+	}
+	if core.Settings.Store.IsSet("can.obd.interval") {
+		return core.Settings.Store.GetDuration("can.obd.interval")
+	}
+	return defaultOBDInterval
+}
+
+// IgnitionOff determines if the car is known to be off, when the engine won't answer requests
+func IgnitionOff() bool {
+	return core.Session.Store.IsSet("ignition") && !core.Session.Store.GetBool("ignition")
+}
+
+// pollOBD publishes each PID in can.obd.pids to obd.<name> as it comes due, while the ignition is on
+func pollOBD() {
+	names := defaultPIDs
+	if core.Settings.Store.IsSet("can.obd.pids") {
+		names = core.Settings.Store.GetStringSlice("can.obd.pids")
+	}
+	for _, name := range names {
+		if _, ok := pids[strings.ToLower(name)]; !ok {
+			log.Warn().Msgf("Skipping unknown OBD PID %s", name)
+		}
+	}
+
+	log.Info().Msgf("Polling OBD PIDs %s", strings.Join(names, ", "))
+	lastPolled := make(map[string]time.Time)
+	ticker := time.NewTicker(250 * time.Millisecond)
+	for range ticker.C {
+		if currentBus() == nil || IgnitionOff() {
+			continue
+		}
+
+		now := time.Now()
+		for _, name := range names {
+			name = strings.ToLower(name)
+			if _, ok := pids[name]; !ok || now.Sub(lastPolled[name]) < obdInterval(name) {
+				continue
+			}
+			lastPolled[name] = now
+
+			value, err := QueryPID(context.Background(), name)
+			if err != nil {
+				log.Debug().Err(err).Msgf("Failed to poll OBD PID %s", name)
+				continue
+			}
+			core.Session.Publish(fmt.Sprintf("obd.%s", name), value)
+		}
+	}
+}
+
+// HandleOBD queries a single PID by name
+func HandleOBD(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["pid"]
+	value, err := QueryPID(r.Context(), name)
+	if err != nil {
+		core.Log(r.Context()).Error().Err(err).Msgf("Failed to query OBD PID %s", name)
+		core.WriteNewResponse(&w, r, core.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
+	core.WriteNewResponse(&w, r, core.JSONResponse{Output: value, OK: true})
+}
@@ -0,0 +1,85 @@
+package can
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/qcasey/mdroid/pkg/core"
+	"github.com/rs/zerolog/log"
+)
+
+// replayPrefix marks a can device as a candump log to be replayed, i.e. replay:/var/log/mdroid/can/capture.log
+const replayPrefix = "replay:"
+
+// isReplay determines if the can device is a captured log rather than an interface
+func isReplay(devicePath string) bool {
+	return strings.HasPrefix(devicePath, replayPrefix)
+}
+
+// replay feeds a candump log through handleCANFrame at its original timing,
+// scaled by can.replay_speed. A speed of 0 replays as fast as possible.
+// Replays stop when the log can't be read, or has no frames to loop over
+func replay(path string) {
+	speed := 1.0
+	if core.Settings.Store.IsSet("can.replay_speed") {
+		speed = core.Settings.Store.GetFloat64("can.replay_speed")
+	}
+
+	for {
+		frames, err := replayFile(path, speed)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to replay can log %s", path)
+			return
+		}
+		if frames == 0 {
+			log.Warn().Msgf("Can log %s has no frames to replay", path)
+			return
+		}
+		if !core.Settings.Store.GetBool("can.replay_loop") {
+			log.Info().Msgf("Finished replaying can log %s", path)
+			return
+		}
+	}
+}
+
+// replayFile feeds the log through once, returning how many frames it replayed
+func replayFile(path string, speed float64) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	log.Info().Msgf("Replaying can log %s at %.2fx speed", path, speed)
+
+	frames := 0
+	var last time.Time
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		timestamp, frm, err := parseCandump(line)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Skipping unreadable line in can log %s", path)
+			continue
+		}
+		// Errors the interface reported weren't traffic on the bus
+		if isErrorFrame(frm) {
+			continue
+		}
+
+		if speed > 0 && !last.IsZero() && timestamp.After(last) {
+			time.Sleep(time.Duration(float64(timestamp.Sub(last)) / speed))
+		}
+		last = timestamp
+
+		handleCANFrame(frm)
+		frames++
+	}
+	return frames, scanner.Err()
+}
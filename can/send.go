@@ -0,0 +1,192 @@
+package can
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/qcasey/mdroid/pkg/core"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	maxStandardID = 0x7FF
+	maxExtendedID = 0x1FFFFFFF
+)
+
+// ErrDisconnected is returned when sending while the CAN bus is down
+var ErrDisconnected = errors.New("can bus is disconnected")
+
+var (
+	// bus is nil while disconnected
+	bus     *can.Bus
+	busLock sync.RWMutex
+)
+
+func currentBus() *can.Bus {
+	busLock.RLock()
+	defer busLock.RUnlock()
+	return bus
+}
+
+func setBus(b *can.Bus) {
+	busLock.Lock()
+	defer busLock.Unlock()
+	bus = b
+}
+
+// clearBus forgets a bus that stopped publishing, unless a newer connection already replaced it
+func clearBus(b *can.Bus) {
+	busLock.Lock()
+	defer busLock.Unlock()
+	if bus == b {
+		bus = nil
+	}
+}
+
+// Send a frame on the connected bus. Extended frames take a 29 bit ID, standard frames an 11 bit one
+func Send(ctx context.Context, id uint32, extended bool, data []byte) error {
+	frm, err := newFrame(id, extended, data)
+	if err != nil {
+		return err
+	}
+
+	b := currentBus()
+	if b == nil {
+		return ErrDisconnected
+	}
+	core.Log(ctx).Debug().Msgf("Sending can frame %x [%d] % X", id, len(data), data)
+	return b.Publish(frm)
+}
+
+func newFrame(id uint32, extended bool, data []byte) (can.Frame, error) {
+	if len(data) > 8 {
+		return can.Frame{}, fmt.Errorf("can frames hold at most 8 bytes, got %d", len(data))
+	}
+
+	frm := can.Frame{ID: id, Length: uint8(len(data))}
+	if extended {
+		if id > maxExtendedID {
+			return can.Frame{}, fmt.Errorf("extended ID %x is more than 29 bits", id)
+		}
+		frm.ID |= extendedIDFlag
+	} else if id > maxStandardID {
+		return can.Frame{}, fmt.Errorf("standard ID %x is more than 11 bits, send it as extended", id)
+	}
+	copy(frm.Data[:], data)
+	return frm, nil
+}
+
+// parseFrame reads an ID and data written as hex, i.e. "1F8" and "00 00 12"
+func parseFrame(id string, data string) (uint32, []byte, error) {
+	parsedID, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(id), "0x"), 16, 32)
+	if err != nil {
+		return 0, nil, fmt.Errorf("ID %s is not hex", id)
+	}
+	payload, err := hex.DecodeString(strings.ReplaceAll(data, " ", ""))
+	if err != nil {
+		return 0, nil, fmt.Errorf("data %s is not valid hex: %s", data, err.Error())
+	}
+	return uint32(parsedID), payload, nil
+}
+
+// SendRequest is a frame to send, with its ID and data as hex
+type SendRequest struct {
+	ID       string `json:"id"`
+	Extended bool   `json:"extended,omitempty"`
+	Data     string `json:"data"`
+}
+
+// HandleSend sends the frame in the JSON body
+func HandleSend(w http.ResponseWriter, r *http.Request) {
+	var request SendRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		core.WriteNewResponse(&w, r, core.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
+
+	id, data, err := parseFrame(request.ID, request.Data)
+	if err == nil {
+		err = Send(r.Context(), id, request.Extended, data)
+	}
+	if err != nil {
+		core.Log(r.Context()).Error().Err(err).Msg("Failed to send can frame")
+		core.WriteNewResponse(&w, r, core.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
+	core.WriteNewResponse(&w, r, core.JSONResponse{Output: fmt.Sprintf("%X % X", id, data), OK: true})
+}
+
+// Periodic is a frame sent on an interval, set in can.periodic
+type Periodic struct {
+	Name     string        `mapstructure:"name"`
+	ID       string        `mapstructure:"id"` // hex, quoted in YAML
+	Extended bool          `mapstructure:"extended"`
+	Data     string        `mapstructure:"data"`
+	Interval time.Duration `mapstructure:"interval"`
+	// When lists session values that must hold to send, and Unless ones that stop it
+	When   map[string]interface{} `mapstructure:"when"`
+	Unless map[string]interface{} `mapstructure:"unless"`
+}
+
+func (p Periodic) active() bool {
+	if !core.Session.Matches(p.When) {
+		return false
+	}
+	return len(p.Unless) == 0 || !core.Session.Matches(p.Unless)
+}
+
+// startPeriodic starts a sender for each job in can.periodic
+func startPeriodic() {
+	var jobs []Periodic
+	if err := core.Settings.Store.UnmarshalKey("can.periodic", &jobs); err != nil {
+		log.Error().Err(err).Msg("Failed to read can.periodic")
+		return
+	}
+
+	for _, job := range jobs {
+		id, data, err := job.frame()
+		if err != nil {
+			log.Error().Err(err).Msgf("Skipping invalid periodic can job %s", job.Name)
+			continue
+		}
+		go sendPeriodic(job, id, data)
+	}
+}
+
+// frame reads the job's ID and data, checking they make a frame that can be sent on an interval
+func (p Periodic) frame() (uint32, []byte, error) {
+	if p.Interval <= 0 {
+		return 0, nil, fmt.Errorf("interval %s must be positive", p.Interval)
+	}
+	id, data, err := parseFrame(p.ID, p.Data)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err := newFrame(id, p.Extended, data); err != nil {
+		return 0, nil, err
+	}
+	return id, data, nil
+}
+
+// sendPeriodic sends a job's frame every interval, while its conditions hold and the bus is up
+func sendPeriodic(job Periodic, id uint32, data []byte) {
+	log.Info().Msgf("Sending can frame %X every %s for %s", id, job.Interval, job.Name)
+	ticker := time.NewTicker(job.Interval)
+	for range ticker.C {
+		if currentBus() == nil || !job.active() {
+			continue
+		}
+		if err := Send(context.Background(), id, job.Extended, data); err != nil {
+			log.Error().Err(err).Msgf("Failed to send periodic can frame %s", job.Name)
+		}
+	}
+}
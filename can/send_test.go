@@ -0,0 +1,143 @@
+package can
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/qcasey/mdroid/pkg/core"
+)
+
+func TestNewFrame(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       uint32
+		extended bool
+		data     []byte
+		want     can.Frame
+		ok       bool
+	}{
+		{"standard", 0x1F8, false, []byte{0x00, 0x12}, can.Frame{ID: 0x1F8, Length: 2, Data: [8]uint8{0x00, 0x12}}, true},
+		{"empty", 0x100, false, nil, can.Frame{ID: 0x100}, true},
+		{"full", 0x7FF, false, []byte{1, 2, 3, 4, 5, 6, 7, 8}, can.Frame{ID: 0x7FF, Length: 8, Data: [8]uint8{1, 2, 3, 4, 5, 6, 7, 8}}, true},
+		{"extended", 0x18DB33F1, true, []byte{0x02}, can.Frame{ID: 0x18DB33F1 | extendedIDFlag, Length: 1, Data: [8]uint8{0x02}}, true},
+		{"extended small ID", 0x100, true, nil, can.Frame{ID: 0x100 | extendedIDFlag}, true},
+		{"standard too large", 0x800, false, nil, can.Frame{}, false},
+		{"extended too large", 0x20000000, true, nil, can.Frame{}, false},
+		{"too much data", 0x100, false, make([]byte, 9), can.Frame{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newFrame(tt.id, tt.extended, tt.data)
+			if (err == nil) != tt.ok {
+				t.Fatalf("newFrame() error = %v, want ok %t", err, tt.ok)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newFrame() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFrame(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     string
+		data   string
+		wantID uint32
+		want   []byte
+		ok     bool
+	}{
+		{"spaced", "1F8", "00 00 12", 0x1F8, []byte{0x00, 0x00, 0x12}, true},
+		{"prefixed", "0x7DF", "0201", 0x7DF, []byte{0x02, 0x01}, true},
+		{"upper prefix", "0X7DF", "02", 0x7DF, []byte{0x02}, true},
+		{"no data", "100", "", 0x100, []byte{}, true},
+		{"invalid ID", "XYZ", "00", 0, nil, false},
+		{"odd data", "100", "0", 0, nil, false},
+		{"invalid data", "100", "GG", 0, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, data, err := parseFrame(tt.id, tt.data)
+			if (err == nil) != tt.ok {
+				t.Fatalf("parseFrame() error = %v, want ok %t", err, tt.ok)
+			}
+			if id != tt.wantID || !reflect.DeepEqual(data, tt.want) {
+				t.Errorf("parseFrame() = %X, % X, want %X, % X", id, data, tt.wantID, tt.want)
+			}
+		})
+	}
+}
+
+func TestPeriodicFrame(t *testing.T) {
+	tests := []struct {
+		name string
+		job  Periodic
+		ok   bool
+	}{
+		{"valid", Periodic{ID: "3B4", Data: "00 01", Interval: time.Second}, true},
+		{"extended", Periodic{ID: "18DB33F1", Extended: true, Data: "02", Interval: time.Second}, true},
+		{"no interval", Periodic{ID: "3B4", Data: "00"}, false},
+		{"invalid ID", Periodic{ID: "none", Data: "00", Interval: time.Second}, false},
+		{"standard ID too large", Periodic{ID: "18DB33F1", Data: "00", Interval: time.Second}, false},
+		{"too much data", Periodic{ID: "3B4", Data: "00 01 02 03 04 05 06 07 08", Interval: time.Second}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := tt.job.frame(); (err == nil) != tt.ok {
+				t.Errorf("frame() error = %v, want ok %t", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestPeriodicActive(t *testing.T) {
+	core.Session = core.NewDatastore(false)
+	core.Session.Store.Set("ignition", true)
+	core.Session.Store.Set("gear", "PARK")
+
+	tests := []struct {
+		name string
+		job  Periodic
+		want bool
+	}{
+		{"always", Periodic{}, true},
+		{"when holds", Periodic{When: map[string]interface{}{"ignition": true}}, true},
+		{"when fails", Periodic{When: map[string]interface{}{"ignition": false}}, false},
+		{"when unset", Periodic{When: map[string]interface{}{"unlock_power": true}}, false},
+		{"unless holds", Periodic{Unless: map[string]interface{}{"gear": "park"}}, false},
+		{"unless fails", Periodic{When: map[string]interface{}{"ignition": true}, Unless: map[string]interface{}{"gear": "DRIVE"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.job.active(); got != tt.want {
+				t.Errorf("active() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClearBus(t *testing.T) {
+	defer setBus(nil)
+
+	old, current := &can.Bus{}, &can.Bus{}
+	setBus(current)
+	clearBus(old)
+	if currentBus() != current {
+		t.Fatal("clearing an old bus dropped the current one")
+	}
+
+	clearBus(current)
+	if currentBus() != nil {
+		t.Fatal("the current bus wasn't cleared")
+	}
+	if err := Send(context.Background(), 0x100, false, nil); err != ErrDisconnected {
+		t.Errorf("Send() = %v without a bus, want ErrDisconnected", err)
+	}
+}
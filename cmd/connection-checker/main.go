@@ -26,7 +26,17 @@ func makeRequest(url string, value bool) {
 		log.Err(err)
 		return
 	}
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(reqBody))
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(reqBody))
+	if err != nil {
+		log.Err(err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// The same token as mdroidctl, MDROID_* variables are read as server settings
+	if token := os.Getenv("MDROIDCTL_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		log.Err(err)
 		return
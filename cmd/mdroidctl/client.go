@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// client talks to the MDroid server over TCP or a unix socket
+type client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+	stream  *http.Client
+}
+
+func newClient(addr string, socket string, token string) *client {
+	transport := &http.Transport{}
+	baseURL := strings.TrimSuffix(addr, "/")
+	if !strings.Contains(baseURL, "://") {
+		baseURL = "http://" + baseURL
+	}
+
+	if socket != "" {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		// The host is ignored when dialing the socket
+		baseURL = "http://mdroid"
+	}
+
+	return &client{
+		baseURL: baseURL,
+		token:   token,
+		http:    &http.Client{Transport: transport, Timeout: 10 * time.Second},
+		stream:  &http.Client{Transport: transport},
+	}
+}
+
+// do sends a request, returning the decoded output of a successful response
+func (c *client) do(method string, path string, body interface{}) (interface{}, error) {
+	resp, err := c.send(c.http, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var output interface{}
+	if len(bytes.TrimSpace(raw)) > 0 {
+		if err := json.Unmarshal(raw, &output); err != nil {
+			return nil, fmt.Errorf("invalid response from server: %s", err.Error())
+		}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if output == nil {
+			return nil, fmt.Errorf("%s %s failed: %s", method, path, resp.Status)
+		}
+		return nil, fmt.Errorf("%s %s failed: %v", method, path, output)
+	}
+	return output, nil
+}
+
+func (c *client) send(httpClient *http.Client, method string, path string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unauthorized, check MDROIDCTL_TOKEN")
+	}
+	return resp, nil
+}
+
+// print sends a request and writes its output in the given format
+func (c *client) print(format string, method string, path string, body interface{}) error {
+	output, err := c.do(method, path, body)
+	if err != nil {
+		return err
+	}
+	return render(format, output)
+}
+
+// streamEvent is a single server-sent event
+type streamEvent struct {
+	name string
+	data []byte
+}
+
+// watch follows a server-sent event stream until it is closed
+func (c *client) watch(format string, path string) error {
+	return c.follow(path, func(event streamEvent) error {
+		if format == "json" {
+			fmt.Println(string(event.data))
+			return nil
+		}
+
+		var update struct {
+			Topic string      `json:"topic"`
+			Value interface{} `json:"value"`
+			Time  time.Time   `json:"time"`
+		}
+		if err := json.Unmarshal(event.data, &update); err != nil {
+			return err
+		}
+		fmt.Printf("%s  %-40s %s\n", update.Time.Format("15:04:05.000"), update.Topic, formatValue(update.Value))
+		return nil
+	})
+}
+
+// follow reads a server-sent event stream, handing each event to handle
+func (c *client) follow(path string, handle func(streamEvent) error) error {
+	resp, err := c.send(c.stream, "GET", path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s failed: %s", path, resp.Status)
+	}
+
+	var event streamEvent
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event.name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			event.data = append(event.data, strings.TrimSpace(strings.TrimPrefix(line, "data:"))...)
+		case line == "" && len(event.data) > 0:
+			if err := handle(event); err != nil {
+				return err
+			}
+			event = streamEvent{}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("stream closed by server")
+}
@@ -0,0 +1,193 @@
+// mdroidctl is a command line client for the MDroid REST API
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const usage = `Usage: mdroidctl [flags] <command> [args]
+
+Commands:
+  get [key]                     Show the session, or a single session value
+  set <key> <value>             Set a session value
+  watch [key...]                Stream session updates, optionally only for the given keys
+  settings [key [value]]        Show all settings, a single setting, or change one
+  kbus send <command>           Send a prepared kbus command, i.e. RequestDoorStatus
+  kbus send <src> <dest> <data> Send raw kbus data as hex, i.e. kbus send 68 18 "0A 01"
+  kbus request <command>        Send a kbus request and show its reply, i.e. RequestOdometer
+  kbus macro <name>             Run a kbus macro from kbus.macros, i.e. RollWindowsUp
+  kbus display <target> <text>  Show text on the ike, mid or radio display
+  kbus sniff [flags]            Stream packets read from the kbus, see kbus sniff -h
+  kbus unknown [export|reset]   Show, export as translation rules, or clear unknown packets
+  can send <id> <data> [-x]     Send a can frame as hex, -x for an extended ID
+  diagnostics [clear|history]   Read, clear or show the history of trouble codes
+  serial send <command>         Write a command to the serial devices
+  components                    Show the state of every component
+  health                        Check that MDroid is up
+
+Environment:
+  MDROIDCTL_ADDR    Address of the MDroid server (default http://localhost:5353)
+  MDROIDCTL_SOCKET  Unix socket to connect to instead of MDROIDCTL_ADDR
+  MDROIDCTL_TOKEN   API token, if the server requires one
+
+These don't share the MDROID_ prefix, which the server reads settings from.
+The server's own token is mdroid.token, i.e. MDROID_MDROID__TOKEN.
+
+Flags:
+`
+
+func main() {
+	flags := flag.NewFlagSet("mdroidctl", flag.ExitOnError)
+	addr := flags.String("addr", envOrDefault("MDROIDCTL_ADDR", "http://localhost:5353"), "address of the MDroid server")
+	socket := flags.String("socket", os.Getenv("MDROIDCTL_SOCKET"), "unix socket to connect to instead of -addr")
+	output := flags.String("o", "table", "output format, table or json")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+
+	if *output != "table" && *output != "json" {
+		fail(fmt.Errorf("unknown output format %s", *output))
+	}
+
+	args := flags.Args()
+	if len(args) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	c := newClient(*addr, *socket, os.Getenv("MDROIDCTL_TOKEN"))
+	if err := run(c, *output, args); err != nil {
+		fail(err)
+	}
+}
+
+func run(c *client, format string, args []string) error {
+	command, args := args[0], args[1:]
+
+	switch command {
+	case "get":
+		if len(args) == 0 {
+			return c.print(format, "GET", "/session", nil)
+		}
+		return c.print(format, "GET", "/session/"+url.PathEscape(args[0]), nil)
+
+	case "set":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: mdroidctl set <key> <value>")
+		}
+		return c.print(format, "POST", "/session/"+url.PathEscape(args[0]), map[string]interface{}{"value": parseValue(args[1])})
+
+	case "watch":
+		query := url.Values{}
+		for _, topic := range args {
+			query.Add("topic", topic)
+		}
+		return c.watch(format, "/session/stream?"+query.Encode())
+
+	case "settings":
+		switch len(args) {
+		case 0:
+			return c.print(format, "GET", "/settings", nil)
+		case 1:
+			return c.print(format, "GET", "/settings/"+url.PathEscape(args[0]), nil)
+		case 2:
+			return c.print(format, "POST", fmt.Sprintf("/settings/%s/%s", url.PathEscape(args[0]), url.PathEscape(args[1])), nil)
+		}
+		return fmt.Errorf("usage: mdroidctl settings [key [value]]")
+
+	case "kbus":
+		if len(args) > 0 && args[0] == "sniff" {
+			return c.sniff(format, args[1:])
+		}
+		if len(args) > 0 && args[0] == "unknown" {
+			switch {
+			case len(args) == 1:
+				return c.print(format, "GET", "/kbus/unknown", nil)
+			case args[1] == "export":
+				return c.print("json", "GET", "/kbus/unknown/export", nil)
+			case args[1] == "reset":
+				return c.print(format, "DELETE", "/kbus/unknown", nil)
+			}
+			return fmt.Errorf("usage: mdroidctl kbus unknown [export|reset]")
+		}
+		if len(args) >= 3 && args[0] == "display" {
+			return c.print(format, "POST", "/kbus/display/"+url.PathEscape(args[1]), map[string]string{"text": strings.Join(args[2:], " ")})
+		}
+		if len(args) == 2 && args[0] == "macro" {
+			return c.print(format, "POST", "/kbus/macro/"+url.PathEscape(args[1]), nil)
+		}
+		if len(args) == 2 && args[0] == "request" {
+			return c.print(format, "GET", "/kbus/request/"+url.PathEscape(args[1]), nil)
+		}
+		if len(args) < 2 || args[0] != "send" {
+			return fmt.Errorf("usage: mdroidctl kbus send <command> | <src> <dest> <data>, or kbus request <command>")
+		}
+		switch len(args) {
+		case 2:
+			return c.print(format, "GET", "/kbus/"+url.PathEscape(args[1]), nil)
+		case 4:
+			return c.print(format, "POST", "/kbus/raw", map[string]string{"source": args[1], "destination": args[2], "data": args[3]})
+		}
+		return fmt.Errorf("usage: mdroidctl kbus send <command> | <src> <dest> <data>")
+
+	case "can":
+		if len(args) < 3 || args[0] != "send" {
+			return fmt.Errorf("usage: mdroidctl can send <id> <data> [-x]")
+		}
+		extended := len(args) > 3 && args[3] == "-x"
+		return c.print(format, "POST", "/can/send", map[string]interface{}{"id": args[1], "data": args[2], "extended": extended})
+
+	case "diagnostics":
+		switch {
+		case len(args) == 0:
+			return c.print(format, "GET", "/diagnostics/dtc", nil)
+		case args[0] == "clear":
+			return c.print(format, "DELETE", "/diagnostics/dtc", nil)
+		case args[0] == "history":
+			return c.print(format, "GET", "/diagnostics/history", nil)
+		}
+		return fmt.Errorf("usage: mdroidctl diagnostics [clear|history]")
+
+	case "serial":
+		if len(args) != 2 || args[0] != "send" {
+			return fmt.Errorf("usage: mdroidctl serial send <command>")
+		}
+		return c.print(format, "POST", "/serial/"+url.PathEscape(args[1]), nil)
+
+	case "components":
+		return c.print(format, "GET", "/components", nil)
+
+	case "health":
+		return c.print(format, "GET", "/health", nil)
+	}
+
+	return fmt.Errorf("unknown command %s, see mdroidctl -h", command)
+}
+
+// parseValue treats the value as JSON if possible, so `true` and `12` aren't sent as strings
+func parseValue(value string) interface{} {
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+		return value
+	}
+	return parsed
+}
+
+func envOrDefault(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "mdroidctl: %s\n", strings.TrimSpace(err.Error()))
+	os.Exit(1)
+}
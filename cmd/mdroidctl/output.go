@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// render writes a decoded API response as a table or JSON
+func render(format string, output interface{}) error {
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(output)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	switch vv := output.(type) {
+	case map[string]interface{}:
+		flat := make(map[string]interface{})
+		flatten("", vv, flat)
+		keys := make([]string, 0, len(flat))
+		for k := range flat {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintln(w, "KEY\tVALUE")
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s\t%s\n", k, formatValue(flat[k]))
+		}
+	case []interface{}:
+		renderRows(w, vv)
+	default:
+		fmt.Fprintln(w, formatValue(vv))
+	}
+	return w.Flush()
+}
+
+// renderRows writes a list of objects with one column per field
+func renderRows(w *tabwriter.Writer, rows []interface{}) {
+	var columns []string
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		if fields, ok := row.(map[string]interface{}); ok {
+			for k := range fields {
+				if !seen[k] {
+					seen[k] = true
+					columns = append(columns, k)
+				}
+			}
+		}
+	}
+
+	// Not a list of objects, print one value per line
+	if len(columns) == 0 {
+		for _, row := range rows {
+			fmt.Fprintln(w, formatValue(row))
+		}
+		return
+	}
+
+	// Name always leads, the rest are alphabetical
+	sort.Slice(columns, func(i, j int) bool {
+		if columns[i] == "name" || columns[j] == "name" {
+			return columns[i] == "name"
+		}
+		return columns[i] < columns[j]
+	})
+
+	fmt.Fprintln(w, strings.ToUpper(strings.Join(columns, "\t")))
+	for _, row := range rows {
+		fields, _ := row.(map[string]interface{})
+		values := make([]string, len(columns))
+		for i, column := range columns {
+			values[i] = formatValue(fields[column])
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+}
+
+// flatten nested maps into dotted keys, the same way MDroid addresses them
+func flatten(prefix string, m map[string]interface{}, into map[string]interface{}) {
+	for k, v := range m {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if nested, ok := v.(map[string]interface{}); ok {
+			flatten(key, nested, into)
+			continue
+		}
+		into[key] = v
+	}
+}
+
+func formatValue(value interface{}) string {
+	switch vv := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return vv
+	case map[string]interface{}, []interface{}:
+		encoded, err := json.Marshal(vv)
+		if err != nil {
+			return fmt.Sprintf("%v", vv)
+		}
+		return string(encoded)
+	}
+	return fmt.Sprintf("%v", value)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// sniff follows packets read from the kbus, filtered by the given flags
+func (c *client) sniff(format string, args []string) error {
+	flags := flag.NewFlagSet("kbus sniff", flag.ContinueOnError)
+	src := flags.String("src", "", "only show packets from this source, as hex")
+	dest := flags.String("dest", "", "only show packets to this destination, as hex")
+	prefix := flags.String("prefix", "", "only show packets whose data starts with these hex bytes")
+	unknown := flags.Bool("unknown", false, "only show packets without a known meaning")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	if *src != "" {
+		query.Set("src", *src)
+	}
+	if *dest != "" {
+		query.Set("dest", *dest)
+	}
+	if *prefix != "" {
+		query.Set("prefix", *prefix)
+	}
+	if *unknown {
+		query.Set("unknown", "true")
+	}
+
+	return c.follow("/kbus/sniff?"+query.Encode(), func(event streamEvent) error {
+		if format == "json" {
+			fmt.Println(string(event.data))
+			return nil
+		}
+
+		var packet struct {
+			Time            time.Time `json:"time"`
+			Source          string    `json:"source"`
+			SourceName      string    `json:"source_name"`
+			Destination     string    `json:"destination"`
+			DestinationName string    `json:"destination_name"`
+			Data            string    `json:"data"`
+			Meaning         string    `json:"meaning"`
+		}
+		if err := json.Unmarshal(event.data, &packet); err != nil {
+			return err
+		}
+
+		meaning := packet.Meaning
+		if meaning == "" {
+			meaning = "?"
+		}
+		line := fmt.Sprintf("%s  %-4s (%s) -> %-4s (%s)  %-20s %s",
+			packet.Time.Format("15:04:05.000"),
+			packet.SourceName, packet.Source,
+			packet.DestinationName, packet.Destination,
+			meaning, packet.Data)
+		fmt.Println(strings.TrimSpace(line))
+		return nil
+	})
+}
@@ -0,0 +1,302 @@
+// Package diagnostics reads and clears engine trouble codes over OBD-II, keeping a history of when they appeared
+package diagnostics
+
+import (
+	"context"
+	_ "embed" // Bundled trouble code descriptions
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qcasey/mdroid/can"
+	"github.com/qcasey/mdroid/pkg/core"
+	"github.com/qcasey/mdroid/pkg/server"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v2"
+)
+
+//go:embed codes.yaml
+var defaultCodes []byte
+
+// OBD-II services for trouble codes, answered with the service plus 0x40
+const (
+	readStored  = 0x03
+	clearCodes  = 0x04
+	readPending = 0x07
+)
+
+const (
+	// requestID addresses every emissions ECU at once
+	requestID       = 0x7DF
+	defaultInterval = time.Minute
+	// checkEngineLight is the can signal that triggers a read as soon as it turns on
+	checkEngineLight = "Check_Engine_Light"
+)
+
+// DTC is a diagnostic trouble code currently set
+type DTC struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+	// Pending codes haven't failed often enough to turn on the check engine light
+	Pending bool `json:"pending"`
+}
+
+// Reading is the result of the latest read of the trouble codes
+type Reading struct {
+	Codes []DTC     `json:"codes"`
+	Time  time.Time `json:"time"`
+}
+
+// codes describes each known trouble code
+var codes map[string]string
+
+var (
+	// lastReading is nil until the codes are first read
+	lastReading *Reading
+	readingLock sync.Mutex
+)
+
+func setReading(dtcs []DTC, at time.Time) {
+	readingLock.Lock()
+	lastReading = &Reading{Codes: dtcs, Time: at}
+	readingLock.Unlock()
+}
+
+// Start reads trouble codes every diagnostics.interval
+func Start(srv *server.Server) {
+	// Check if enabled
+	if !core.Settings.Store.GetBool("diagnostics.enabled") {
+		log.Info().Msg("Started diagnostics without enabling in the config. Skipping module...")
+		return
+	}
+	if !core.Settings.Store.GetBool("can.enabled") {
+		log.Warn().Msg("Diagnostics reads trouble codes over can, which isn't enabled")
+	}
+
+	var err error
+	codes, err = loadCodes(core.Settings.Store.GetString("diagnostics.code_table"))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load trouble code table")
+		return
+	}
+	if err := loadHistory(); err != nil {
+		log.Error().Err(err).Msg("Failed to load trouble code history")
+	}
+
+	srv.Router.HandleFunc("/diagnostics/dtc", HandleCodes).Methods("GET")
+	srv.Router.HandleFunc("/diagnostics/dtc", HandleClear).Methods("DELETE")
+	srv.Router.HandleFunc("/diagnostics/history", HandleHistory).Methods("GET")
+
+	go poll()
+}
+
+// loadCodes parses the bundled code table, replacing or adding the codes in the given file
+func loadCodes(path string) (map[string]string, error) {
+	table := make(map[string]string)
+	if err := yaml.Unmarshal(defaultCodes, &table); err != nil {
+		return nil, fmt.Errorf("invalid bundled code table: %s", err.Error())
+	}
+	if path == "" {
+		return table, nil
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	custom := make(map[string]string)
+	if err := yaml.Unmarshal(contents, &custom); err != nil {
+		return nil, fmt.Errorf("invalid code table %s: %s", path, err.Error())
+	}
+	for code, description := range custom {
+		table[strings.ToUpper(code)] = description
+	}
+	return table, nil
+}
+
+// describe a trouble code, falling back to its system when it isn't in the table
+func describe(code string) string {
+	if description, ok := codes[code]; ok {
+		return description
+	}
+
+	systems := map[byte]string{'P': "Powertrain", 'C': "Chassis", 'B': "Body", 'U': "Network"}
+	if code[1] == '0' || code[1] == '2' {
+		return fmt.Sprintf("%s, generic", systems[code[0]])
+	}
+	return fmt.Sprintf("%s, manufacturer specific", systems[code[0]])
+}
+
+// decodeCode reads a two byte trouble code, i.e. 01 71 is P0171
+func decodeCode(a byte, b byte) string {
+	system := "PCBU"[a>>6]
+	return fmt.Sprintf("%c%d%X%02X", system, (a>>4)&0x03, a&0x0F, b)
+}
+
+// readCodes requests the codes of a service
+func readCodes(ctx context.Context, service byte) ([]string, error) {
+	response, err := can.Request(ctx, requestID, []byte{service})
+	if err != nil {
+		return nil, err
+	}
+	return parseCodes(service, response)
+}
+
+// parseCodes reads the codes listed in a response to a service. CAN responses count their codes before listing them
+func parseCodes(service byte, response []byte) ([]string, error) {
+	if len(response) < 2 || response[0] != service+0x40 {
+		return nil, fmt.Errorf("unexpected response to service %02X: % X", service, response)
+	}
+
+	var found []string
+	for i := 2; i+1 < len(response); i += 2 {
+		// Responses are padded with empty codes
+		if response[i] == 0 && response[i+1] == 0 {
+			continue
+		}
+		found = append(found, decodeCode(response[i], response[i+1]))
+	}
+	return found, nil
+}
+
+// Read the stored and pending trouble codes, publishing them and recording them in the history
+func Read(ctx context.Context) ([]DTC, error) {
+	stored, err := readCodes(ctx, readStored)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stored codes: %s", err.Error())
+	}
+	pending, err := readCodes(ctx, readPending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pending codes: %s", err.Error())
+	}
+
+	var dtcs []DTC
+	seen := make(map[string]bool)
+	for _, code := range stored {
+		if !seen[code] {
+			seen[code] = true
+			dtcs = append(dtcs, DTC{Code: code, Description: describe(code)})
+		}
+	}
+	for _, code := range pending {
+		if !seen[code] {
+			seen[code] = true
+			dtcs = append(dtcs, DTC{Code: code, Description: describe(code), Pending: true})
+		}
+	}
+	sort.Slice(dtcs, func(i, j int) bool { return dtcs[i].Code < dtcs[j].Code })
+
+	now := time.Now()
+	publish(stored, pending)
+	record(dtcs, now, false)
+	setReading(dtcs, now)
+	return dtcs, nil
+}
+
+// Clear the engine's trouble codes, which also turns off the check engine light
+func Clear(ctx context.Context) error {
+	response, err := can.Request(ctx, requestID, []byte{clearCodes})
+	if err != nil {
+		return err
+	}
+	if len(response) < 1 || response[0] != clearCodes+0x40 {
+		return fmt.Errorf("engine refused to clear codes: % X", response)
+	}
+
+	core.Log(ctx).Info().Msg("Cleared trouble codes")
+	now := time.Now()
+	publish(nil, nil)
+	record(nil, now, true)
+	setReading(nil, now)
+	return nil
+}
+
+// publish the current codes to diagnostics.dtc.*
+func publish(stored []string, pending []string) {
+	core.Session.Publish("diagnostics.dtc.stored", strings.Join(stored, ","))
+	core.Session.Publish("diagnostics.dtc.pending", strings.Join(pending, ","))
+	core.Session.Publish("diagnostics.dtc.count", len(stored))
+}
+
+// pollInterval is how often the codes are read, from diagnostics.interval
+func pollInterval() time.Duration {
+	if !core.Settings.Store.IsSet("diagnostics.interval") {
+		return defaultInterval
+	}
+	// Unreadable intervals read as 0, which a ticker can't run at
+	interval := core.Settings.Store.GetDuration("diagnostics.interval")
+	if interval <= 0 {
+		log.Warn().Msgf("Invalid diagnostics.interval %v, reading trouble codes every %s", core.Settings.Store.Get("diagnostics.interval"), defaultInterval)
+		return defaultInterval
+	}
+	return interval
+}
+
+// poll reads the codes every diagnostics.interval, and as soon as the check engine light turns on.
+// Reads are skipped while the ignition is off
+func poll() {
+	interval := pollInterval()
+
+	// The light is decoded by the can module, which may publish it under another topic
+	light := make(chan core.Message, 1)
+	if topic := can.SignalTopic(checkEngineLight); topic != "" {
+		core.Session.Subscribe(topic, light)
+	} else {
+		log.Info().Msgf("%s isn't published, reading trouble codes every %s", checkEngineLight, interval)
+	}
+
+	ticker := time.NewTicker(interval)
+	for {
+		select {
+		case m := <-light:
+			if on, ok := m.Value.(bool); !ok || !on {
+				continue
+			}
+		case <-ticker.C:
+		}
+
+		if can.IgnitionOff() {
+			continue
+		}
+		if _, err := Read(context.Background()); err != nil {
+			log.Debug().Err(err).Msg("Failed to read trouble codes")
+		}
+	}
+}
+
+// HandleCodes responds with the trouble codes from the latest read. Reads happen every diagnostics.interval
+// and when the check engine light turns on, rather than on request
+func HandleCodes(w http.ResponseWriter, r *http.Request) {
+	readingLock.Lock()
+	reading := lastReading
+	readingLock.Unlock()
+
+	if reading == nil {
+		core.WriteNewResponse(&w, r, core.JSONResponse{Output: "Trouble codes haven't been read yet", OK: false})
+		return
+	}
+	core.WriteNewResponse(&w, r, core.JSONResponse{Output: reading, OK: true})
+}
+
+// HandleClear clears the trouble codes. Since this hides faults, it's refused unless mdroid.token is set
+func HandleClear(w http.ResponseWriter, r *http.Request) {
+	if core.Settings.Store.GetString("mdroid.token") == "" {
+		core.WriteNewResponse(&w, r, core.JSONResponse{Output: "Clearing trouble codes requires mdroid.token to be set", OK: false})
+		return
+	}
+	if !core.IsAuthorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if err := Clear(r.Context()); err != nil {
+		core.Log(r.Context()).Error().Err(err).Msg("Failed to clear trouble codes")
+		core.WriteNewResponse(&w, r, core.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
+	core.WriteNewResponse(&w, r, core.JSONResponse{Output: "Cleared trouble codes", OK: true})
+}
@@ -0,0 +1,292 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qcasey/mdroid/pkg/core"
+)
+
+func TestDecodeCode(t *testing.T) {
+	tests := []struct {
+		a, b byte
+		want string
+	}{
+		{0x01, 0x71, "P0171"},
+		{0x03, 0x00, "P0300"},
+		{0x11, 0x23, "P1123"},
+		{0x2A, 0xBC, "P2ABC"},
+		{0x42, 0x34, "C0234"},
+		{0x80, 0x01, "B0001"},
+		{0xC1, 0x00, "U0100"},
+		{0xFF, 0xFF, "U3FFF"},
+	}
+
+	for _, tt := range tests {
+		if got := decodeCode(tt.a, tt.b); got != tt.want {
+			t.Errorf("decodeCode(%02X, %02X) = %s, want %s", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	codes = map[string]string{"P0171": "System Too Lean (Bank 1)"}
+	defer func() { codes = nil }()
+
+	tests := map[string]string{
+		"P0171": "System Too Lean (Bank 1)",
+		"P0999": "Powertrain, generic",
+		"P2ABC": "Powertrain, generic",
+		"P1123": "Powertrain, manufacturer specific",
+		"C0234": "Chassis, generic",
+		"B3001": "Body, manufacturer specific",
+		"U0100": "Network, generic",
+	}
+	for code, want := range tests {
+		if got := describe(code); got != want {
+			t.Errorf("describe(%s) = %q, want %q", code, got, want)
+		}
+	}
+}
+
+func TestLoadCodes(t *testing.T) {
+	bundled, err := loadCodes("")
+	if err != nil {
+		t.Fatalf("failed to load the bundled table: %s", err.Error())
+	}
+	if bundled["P0171"] == "" {
+		t.Error("the bundled table doesn't describe P0171")
+	}
+
+	dir, err := ioutil.TempDir("", "diagnostics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Custom codes replace or add to the bundled ones, whatever their case
+	custom := filepath.Join(dir, "codes.yaml")
+	ioutil.WriteFile(custom, []byte("P0171: Lean, check the intake boot\np1083: Fuel Control Mixture Lean (Bank 1 Sensor 1)\n"), 0644)
+	table, err := loadCodes(custom)
+	if err != nil {
+		t.Fatalf("failed to load %s: %s", custom, err.Error())
+	}
+	if table["P0171"] != "Lean, check the intake boot" || table["P1083"] != "Fuel Control Mixture Lean (Bank 1 Sensor 1)" {
+		t.Errorf("custom codes weren't loaded: P0171 %q, P1083 %q", table["P0171"], table["P1083"])
+	}
+	if len(table) != len(bundled)+1 {
+		t.Errorf("loaded %d codes, want %d", len(table), len(bundled)+1)
+	}
+
+	invalid := filepath.Join(dir, "invalid.yaml")
+	ioutil.WriteFile(invalid, []byte("- P0171\n"), 0644)
+	for _, path := range []string{invalid, filepath.Join(dir, "missing.yaml")} {
+		if _, err := loadCodes(path); err == nil {
+			t.Errorf("loaded %s without an error", path)
+		}
+	}
+}
+
+func TestPollInterval(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  time.Duration
+	}{
+		{"unset", nil, defaultInterval},
+		{"set", "5m", 5 * time.Minute},
+		{"zero", "0s", defaultInterval},
+		{"negative", "-1m", defaultInterval},
+		{"unreadable", "often", defaultInterval},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core.Settings = core.NewDatastore(false)
+			if tt.value != nil {
+				core.Settings.Store.Set("diagnostics.interval", tt.value)
+			}
+			if got := pollInterval(); got != tt.want {
+				t.Errorf("pollInterval() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleCodes(t *testing.T) {
+	lastReading = nil
+	defer func() { lastReading = nil }()
+
+	// Nothing's served until the codes are read
+	w := httptest.NewRecorder()
+	HandleCodes(w, httptest.NewRequest("GET", "/diagnostics/dtc", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("responded %d before a read, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	at := time.Date(2020, 6, 1, 18, 0, 0, 0, time.UTC)
+	setReading([]DTC{{Code: "P0171", Description: "System Too Lean (Bank 1)"}}, at)
+	w = httptest.NewRecorder()
+	HandleCodes(w, httptest.NewRequest("GET", "/diagnostics/dtc", nil))
+	var reading Reading
+	if err := json.NewDecoder(w.Body).Decode(&reading); err != nil {
+		t.Fatalf("invalid response: %s", err.Error())
+	}
+	if w.Code != http.StatusOK || len(reading.Codes) != 1 || reading.Codes[0].Code != "P0171" || !reading.Time.Equal(at) {
+		t.Errorf("responded %d with %+v, want the latest read", w.Code, reading)
+	}
+}
+
+func TestHandleClear(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		status int
+		output string
+	}{
+		{"no token set", "", "", http.StatusBadRequest, "requires mdroid.token"},
+		{"no token given", "secret", "", http.StatusUnauthorized, ""},
+		{"wrong token", "secret", "Bearer guess", http.StatusUnauthorized, ""},
+		// Authorized requests go on to the engine, which can't be reached here
+		{"authorized", "secret", "Bearer secret", http.StatusBadRequest, "disconnected"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core.Settings = core.NewDatastore(false)
+			core.Settings.Store.Set("mdroid.token", tt.token)
+
+			r := httptest.NewRequest("DELETE", "/diagnostics/dtc", nil)
+			if tt.header != "" {
+				r.Header.Set(core.TokenHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			HandleClear(w, r)
+			if w.Code != tt.status {
+				t.Errorf("responded %d, want %d", w.Code, tt.status)
+			}
+			if !strings.Contains(w.Body.String(), tt.output) {
+				t.Errorf("responded %q, want it to mention %q", w.Body.String(), tt.output)
+			}
+		})
+	}
+}
+
+func TestParseCodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		service  byte
+		response []byte
+		want     []string
+		err      bool
+	}{
+		{"none", readStored, []byte{0x43, 0x00}, nil, false},
+		{"one", readStored, []byte{0x43, 0x01, 0x01, 0x71}, []string{"P0171"}, false},
+		{"padded", readStored, []byte{0x43, 0x02, 0x01, 0x71, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00}, []string{"P0171", "P0300"}, false},
+		{"only padding", readPending, []byte{0x47, 0x00, 0x00, 0x00, 0x00, 0x00}, nil, false},
+		{"trailing byte", readPending, []byte{0x47, 0x01, 0xC1, 0x00, 0x00}, []string{"U0100"}, false},
+		{"wrong service", readStored, []byte{0x47, 0x01, 0x01, 0x71}, nil, true},
+		{"negative response", readStored, []byte{0x7F, 0x03, 0x11}, nil, true},
+		{"too short", readStored, []byte{0x43}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCodes(tt.service, tt.response)
+			if (err != nil) != tt.err {
+				t.Fatalf("parseCodes() error = %v, want error %t", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCodes(% X) = %v, want %v", tt.response, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diagnostics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	core.Settings = core.NewDatastore(false)
+	path := filepath.Join(dir, "history.json")
+	core.Settings.Store.Set("diagnostics.history_file", path)
+	history = nil
+
+	lean := DTC{Code: "P0171", Description: "System Too Lean (Bank 1)"}
+	misfire := DTC{Code: "P0300", Description: "Random/Multiple Cylinder Misfire Detected", Pending: true}
+	start := time.Date(2020, 6, 1, 18, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+
+	// Each step records a read, then checks every occurrence in the history
+	steps := []struct {
+		name    string
+		dtcs    []DTC
+		cleared bool
+		want    []Occurrence
+	}{
+		{"first read", []DTC{lean}, false, []Occurrence{
+			{DTC: lean, FirstSeen: at(0), LastSeen: at(0)},
+		}},
+		{"still set", []DTC{lean}, false, []Occurrence{
+			{DTC: lean, FirstSeen: at(0), LastSeen: at(1)},
+		}},
+		{"pending code", []DTC{lean, misfire}, false, []Occurrence{
+			{DTC: lean, FirstSeen: at(0), LastSeen: at(2)},
+			{DTC: misfire, FirstSeen: at(2), LastSeen: at(2)},
+		}},
+		{"pending code stored", []DTC{lean, {Code: misfire.Code, Description: misfire.Description}}, false, []Occurrence{
+			{DTC: lean, FirstSeen: at(0), LastSeen: at(3)},
+			{DTC: DTC{Code: misfire.Code, Description: misfire.Description}, FirstSeen: at(2), LastSeen: at(3)},
+		}},
+		{"code gone", []DTC{{Code: misfire.Code, Description: misfire.Description}}, false, []Occurrence{
+			{DTC: lean, FirstSeen: at(0), LastSeen: at(3), Resolved: timePointer(at(4))},
+			{DTC: DTC{Code: misfire.Code, Description: misfire.Description}, FirstSeen: at(2), LastSeen: at(4)},
+		}},
+		{"code back", []DTC{lean, {Code: misfire.Code, Description: misfire.Description}}, false, []Occurrence{
+			{DTC: lean, FirstSeen: at(0), LastSeen: at(3), Resolved: timePointer(at(4))},
+			{DTC: DTC{Code: misfire.Code, Description: misfire.Description}, FirstSeen: at(2), LastSeen: at(5)},
+			{DTC: lean, FirstSeen: at(5), LastSeen: at(5)},
+		}},
+		{"cleared", nil, true, []Occurrence{
+			{DTC: lean, FirstSeen: at(0), LastSeen: at(3), Resolved: timePointer(at(4))},
+			{DTC: DTC{Code: misfire.Code, Description: misfire.Description}, FirstSeen: at(2), LastSeen: at(5), Resolved: timePointer(at(6)), Cleared: true},
+			{DTC: lean, FirstSeen: at(5), LastSeen: at(5), Resolved: timePointer(at(6)), Cleared: true},
+		}},
+	}
+
+	for i, step := range steps {
+		record(step.dtcs, at(i), step.cleared)
+		if !reflect.DeepEqual(history, step.want) {
+			t.Fatalf("%s: history is %+v, want %+v", step.name, history, step.want)
+		}
+	}
+
+	// The history is saved as codes come and go
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("history wasn't saved: %s", err.Error())
+	}
+	var saved []Occurrence
+	if err := json.Unmarshal(contents, &saved); err != nil {
+		t.Fatalf("saved history is invalid: %s", err.Error())
+	}
+	if len(saved) != len(history) || saved[2].Resolved == nil || !saved[2].Resolved.Equal(at(6)) {
+		t.Errorf("saved history is %+v, want %+v", saved, history)
+	}
+}
+
+func timePointer(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,126 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/qcasey/mdroid/pkg/core"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultHistoryFile = "/var/log/mdroid/diagnostics/history.json"
+
+// Occurrence is a span of time a trouble code was set
+type Occurrence struct {
+	DTC
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// Resolved is when the code was no longer set, or was cleared
+	Resolved *time.Time `json:"resolved,omitempty"`
+	Cleared  bool       `json:"cleared,omitempty"`
+}
+
+var (
+	history     []Occurrence
+	historyLock sync.Mutex
+)
+
+func historyFile() string {
+	if core.Settings.Store.IsSet("diagnostics.history_file") {
+		return core.Settings.Store.GetString("diagnostics.history_file")
+	}
+	return defaultHistoryFile
+}
+
+// loadHistory reads the saved history, if there is one
+func loadHistory() error {
+	contents, err := ioutil.ReadFile(historyFile())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	historyLock.Lock()
+	defer historyLock.Unlock()
+	return json.Unmarshal(contents, &history)
+}
+
+// saveHistory writes the history to disk. historyLock must be held
+func saveHistory() {
+	path := historyFile()
+	contents, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode trouble code history")
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Error().Err(err).Msgf("Failed to create directory for %s", path)
+		return
+	}
+	if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+		log.Error().Err(err).Msgf("Failed to save trouble code history to %s", path)
+	}
+}
+
+// record the codes read at the given time, opening occurrences for new codes and resolving ones that are gone.
+// The history is saved when codes come or go, last_seen times are saved along with them
+func record(dtcs []DTC, now time.Time, cleared bool) {
+	historyLock.Lock()
+	defer historyLock.Unlock()
+
+	set := make(map[string]DTC, len(dtcs))
+	for _, dtc := range dtcs {
+		set[dtc.Code] = dtc
+	}
+
+	changed := false
+	open := make(map[string]bool)
+	for i := range history {
+		o := &history[i]
+		if o.Resolved != nil {
+			continue
+		}
+		dtc, ok := set[o.Code]
+		if !ok {
+			o.Resolved = &now
+			o.Cleared = cleared
+			changed = true
+			log.Info().Msgf("Trouble code %s is no longer set", o.Code)
+			continue
+		}
+
+		open[o.Code] = true
+		o.LastSeen = now
+		if o.Pending != dtc.Pending {
+			o.Pending = dtc.Pending
+			changed = true
+		}
+	}
+
+	for _, dtc := range dtcs {
+		if open[dtc.Code] {
+			continue
+		}
+		history = append(history, Occurrence{DTC: dtc, FirstSeen: now, LastSeen: now})
+		changed = true
+		log.Warn().Msgf("Trouble code %s set: %s", dtc.Code, dtc.Description)
+	}
+
+	if changed {
+		saveHistory()
+	}
+}
+
+// HandleHistory responds with every recorded occurrence of a trouble code
+func HandleHistory(w http.ResponseWriter, r *http.Request) {
+	historyLock.Lock()
+	defer historyLock.Unlock()
+	core.WriteNewResponse(&w, r, core.JSONResponse{Output: history, OK: true})
+}
@@ -0,0 +1,177 @@
+package kbus
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/qcasey/mdroid/bluetooth"
+	"github.com/qcasey/mdroid/mserial"
+	"github.com/qcasey/mdroid/pkg/core"
+	"github.com/rs/zerolog/log"
+)
+
+// localAddress is where relative HTTP actions are sent
+const localAddress = "http://localhost:5353"
+
+// Action is what a steering wheel button does when pressed
+type Action struct {
+	// Type is one of bluetooth, macro, serial, setting or http
+	Type string `mapstructure:"type"`
+	// Command is the bluetooth call (next, prev, play, pause, toggle), macro name or serial command
+	Command string `mapstructure:"command"`
+	// Key is the setting toggled
+	Key string `mapstructure:"key"`
+	// Method and URL of an HTTP call. URLs starting with / are sent to MDroid itself
+	Method string `mapstructure:"method"`
+	URL    string `mapstructure:"url"`
+}
+
+// ButtonProfile maps buttons to actions while its session conditions hold,
+// i.e. when: {media_source: bluetooth} or when: {seat_memory_2: true}
+type ButtonProfile struct {
+	Name    string                 `mapstructure:"name"`
+	When    map[string]interface{} `mapstructure:"when"`
+	Buttons map[string]Action      `mapstructure:"buttons"`
+}
+
+// defaultButtons are used for any button not mapped by a matching profile in kbus.steering_wheel.
+// Gestures other than a short press are mapped as <button>_<gesture>, i.e. next_long or speak_double
+var defaultButtons = map[string]Action{
+	"next":  {Type: "bluetooth", Command: "next"},
+	"prev":  {Type: "bluetooth", Command: "prev"},
+	"rt":    {Type: "macro", Command: "SteeringWheelRT"},
+	"speak": {Type: "macro", Command: "SteeringWheelSpeak"},
+
+	// Radio controls of the emulated CD changer, cdc_disc_1 to cdc_disc_6 are left to be mapped
+	"cdc_next": {Type: "bluetooth", Command: "next"},
+	"cdc_prev": {Type: "bluetooth", Command: "prev"},
+	"cdc_play": {Type: "bluetooth", Command: "play"},
+	"cdc_stop": {Type: "bluetooth", Command: "pause"},
+}
+
+// findAction for a button, from the first profile in kbus.steering_wheel whose conditions hold
+func findAction(button string) (Action, string, bool) {
+	var profiles []ButtonProfile
+	if err := core.Settings.Store.UnmarshalKey("kbus.steering_wheel", &profiles); err != nil {
+		log.Error().Err(err).Msg("Failed to read kbus.steering_wheel, using the default buttons")
+		profiles = nil
+	}
+
+	for _, profile := range profiles {
+		action, ok := profile.Buttons[button]
+		if ok && core.Session.Matches(profile.When) {
+			return action, profile.Name, true
+		}
+	}
+
+	action, ok := defaultButtons[button]
+	return action, "default", ok
+}
+
+// pressButton runs the action mapped to a steering wheel button
+func pressButton(ctx context.Context, button string) {
+	logger := core.Log(ctx)
+	action, profile, ok := findAction(button)
+	if !ok {
+		logger.Debug().Msgf("Steering wheel button %s has no action", button)
+		return
+	}
+
+	logger.Info().Msgf("Steering wheel button %s runs %s (%s profile)", button, action, profile)
+	if err := action.run(ctx); err != nil {
+		logger.Error().Err(err).Msgf("Failed to run steering wheel action for %s", button)
+	}
+}
+
+func (a Action) String() string {
+	switch a.Type {
+	case "setting":
+		return fmt.Sprintf("setting %s", a.Key)
+	case "http":
+		return fmt.Sprintf("http %s %s", a.Method, a.URL)
+	}
+	return fmt.Sprintf("%s %s", a.Type, a.Command)
+}
+
+func (a Action) run(ctx context.Context) error {
+	switch a.Type {
+	case "bluetooth":
+		switch strings.ToLower(a.Command) {
+		case "next":
+			bluetooth.Next()
+		case "prev":
+			bluetooth.Prev()
+		case "play":
+			bluetooth.Play()
+		case "pause":
+			bluetooth.Pause()
+		case "toggle":
+			if bluetooth.IsPlaying() {
+				bluetooth.Pause()
+			} else {
+				bluetooth.Play()
+			}
+		default:
+			return fmt.Errorf("unknown bluetooth command %s", a.Command)
+		}
+		return nil
+
+	case "macro":
+		return RunMacro(ctx, a.Command)
+
+	case "serial":
+		if !mserial.Enabled {
+			return fmt.Errorf("mserial is not enabled")
+		}
+		mserial.AwaitContext(ctx, a.Command)
+		return nil
+
+	case "setting":
+		if a.Key == "" {
+			return fmt.Errorf("setting action needs a key")
+		}
+		core.Settings.Publish(a.Key, !core.Settings.Store.GetBool(a.Key))
+		return nil
+
+	case "http":
+		return a.call(ctx)
+	}
+	return fmt.Errorf("unknown action type %s", a.Type)
+}
+
+// call the action's URL, sending requests to MDroid with its token and correlation ID
+func (a Action) call(ctx context.Context) error {
+	method := a.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	url := a.URL
+	isLocal := strings.HasPrefix(url, "/")
+	if isLocal {
+		url = localAddress + url
+	}
+
+	req, err := http.NewRequest(strings.ToUpper(method), url, nil)
+	if err != nil {
+		return err
+	}
+	if isLocal {
+		core.SetToken(req)
+		core.SetRequestID(ctx, req)
+	}
+
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s failed: %s", method, a.URL, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package kbus
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/qcasey/gokbus"
+	"github.com/qcasey/mdroid/pkg/core"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	radioAddress     = 0x68
+	cdcAddress       = 0x18
+	broadcastAddress = 0xFF
+
+	// Radio requests to the CD changer
+	cdcPoll    = 0x01
+	cdcCommand = 0x38
+	// CD changer replies to the radio
+	cdcAnnounce = 0x02
+	cdcStatus   = 0x39
+
+	// Commands, sent as the second byte after cdcCommand
+	cdcGetStatus   = 0x00
+	cdcStop        = 0x01
+	cdcPause       = 0x02
+	cdcPlay        = 0x03
+	cdcScan        = 0x04
+	cdcChangeDisc  = 0x06
+	cdcChangeTrack = 0x0A
+
+	// maxDisc is the number of discs a changer holds
+	maxDisc = 6
+	// cdcPollGap is longer than the radio waits between polls while it's on.
+	// A longer gap means the bus slept, and the radio needs the changer announced again
+	cdcPollGap = time.Minute
+)
+
+// changer is the state of the emulated CD changer
+type changer struct {
+	mutex   sync.Mutex
+	playing bool
+	disc    byte
+	track   byte
+	// lastPoll is when the radio last polled the changer
+	lastPoll time.Time
+}
+
+var cdc = &changer{disc: 1, track: 1}
+
+// startCDC announces the emulated CD changer, so the radio offers it as an input
+func startCDC() {
+	if !core.Settings.Store.GetBool("kbus.cdc.enabled") {
+		return
+	}
+	log.Info().Msg("Emulating a CD changer on the kbus")
+	cdc.publish()
+	respond(context.Background(), cdcAddress, broadcastAddress, cdcAnnounce, 0x01)
+}
+
+// isCDCPacket determines if the radio is talking to the emulated CD changer
+func isCDCPacket(p *gokbus.Packet) bool {
+	return p.Source == radioAddress && p.Destination == cdcAddress && len(p.Data) > 0 &&
+		core.Settings.Store.GetBool("kbus.cdc.enabled")
+}
+
+// handleCDC answers the radio's polls and commands, mapping its controls onto actions
+func handleCDC(p *gokbus.Packet) {
+	ctx := context.Background()
+
+	switch p.Data[0] {
+	case cdcPoll:
+		cdc.mutex.Lock()
+		announce := time.Since(cdc.lastPoll) > cdcPollGap
+		cdc.lastPoll = time.Now()
+		cdc.mutex.Unlock()
+
+		if announce {
+			log.Info().Msg("Announcing the CD changer to the radio")
+			respond(ctx, cdcAddress, broadcastAddress, cdcAnnounce, 0x01)
+			return
+		}
+		respond(ctx, cdcAddress, broadcastAddress, cdcAnnounce, 0x00)
+		return
+	case cdcCommand:
+		if len(p.Data) < 3 {
+			return
+		}
+	default:
+		return
+	}
+
+	command, argument := p.Data[1], p.Data[2]
+	cdc.mutex.Lock()
+	button := ""
+	switch command {
+	case cdcGetStatus:
+	case cdcStop, cdcPause:
+		cdc.playing = false
+		button = "cdc_stop"
+	case cdcPlay:
+		cdc.playing = true
+		button = "cdc_play"
+	case cdcScan:
+		// Scanning back and forth isn't something bluetooth media does well, treat it like a track change
+		button = "cdc_next"
+		if argument == 0x01 {
+			button = "cdc_prev"
+		}
+	case cdcChangeTrack:
+		if argument == 0x00 {
+			cdc.track = cdc.track%99 + 1
+			button = "cdc_next"
+		} else {
+			if cdc.track > 1 {
+				cdc.track--
+			}
+			button = "cdc_prev"
+		}
+	case cdcChangeDisc:
+		if argument >= 1 && argument <= maxDisc {
+			cdc.disc = argument
+			button = fmt.Sprintf("cdc_disc_%d", argument)
+		}
+	default:
+		log.Debug().Msgf("Unhandled CD changer command % X", p.Data)
+	}
+	status := cdc.status()
+	cdc.mutex.Unlock()
+
+	respond(ctx, cdcAddress, radioAddress, status...)
+	cdc.publish()
+	if button != "" {
+		pressButton(ctx, button)
+	}
+}
+
+// status reply for the radio. Expects the mutex to be held
+func (c *changer) status() []byte {
+	if c.playing {
+		return []byte{cdcStatus, 0x02, 0x09, 0x00, 0x3F, 0x00, c.disc, c.track}
+	}
+	return []byte{cdcStatus, 0x00, 0x02, 0x00, 0x3F, 0x00, c.disc, c.track}
+}
+
+func (c *changer) publish() {
+	c.mutex.Lock()
+	playing, disc, track := c.playing, int(c.disc), int(c.track)
+	c.mutex.Unlock()
+
+	core.Session.Publish("cdc.playing", playing)
+	core.Session.Publish("cdc.disc", disc)
+	core.Session.Publish("cdc.track", track)
+}
+
+// respond queues a reply ahead of any user commands or polls
+func respond(ctx context.Context, source byte, destination byte, data ...byte) {
+	if currentDevice() == nil {
+		return
+	}
+	p := gokbus.Packet{Source: source, Destination: destination, Data: data}
+	core.Log(ctx).Debug().Msgf("Responding with kbus packet %02X -> %02X: % X", p.Source, p.Destination, p.Data)
+	writes.push(ctx, priorityResponse, []gokbus.Packet{p})
+}
@@ -0,0 +1,106 @@
+package kbus
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/qcasey/gokbus"
+	"github.com/qcasey/mdroid/pkg/core"
+)
+
+// queuedResponses empties the write queue, returning what would have been written
+func queuedResponses() []gokbus.Packet {
+	var packets []gokbus.Packet
+	for {
+		q, _, ok := writes.pop()
+		if !ok {
+			return packets
+		}
+		packets = append(packets, q.packet)
+	}
+}
+
+func TestCDCSequence(t *testing.T) {
+	core.Settings = core.NewDatastore(false)
+	core.Session = core.NewDatastore(false)
+	core.Settings.Store.Set("kbus.cdc.enabled", true)
+
+	// Map the changer's controls onto settings, so each press can be seen
+	buttons := make(map[string]interface{})
+	for _, button := range []string{"cdc_next", "cdc_prev", "cdc_play", "cdc_stop", "cdc_disc_3"} {
+		buttons[button] = map[string]interface{}{"type": "setting", "key": "pressed." + button}
+	}
+	core.Settings.Store.Set("kbus.steering_wheel", []map[string]interface{}{{"name": "test", "buttons": buttons}})
+
+	cdc = &changer{disc: 1, track: 1}
+	setDevice(&gokbus.KBUS{})
+	setConnected(true)
+	defer setDevice(nil)
+	defer setConnected(false)
+	queuedResponses()
+
+	announce := gokbus.Packet{Source: cdcAddress, Destination: broadcastAddress, Data: []byte{cdcAnnounce, 0x01}}
+	present := gokbus.Packet{Source: cdcAddress, Destination: broadcastAddress, Data: []byte{cdcAnnounce, 0x00}}
+	status := func(playing bool, disc byte, track byte) gokbus.Packet {
+		if playing {
+			return gokbus.Packet{Source: cdcAddress, Destination: radioAddress, Data: []byte{cdcStatus, 0x02, 0x09, 0x00, 0x3F, 0x00, disc, track}}
+		}
+		return gokbus.Packet{Source: cdcAddress, Destination: radioAddress, Data: []byte{cdcStatus, 0x00, 0x02, 0x00, 0x3F, 0x00, disc, track}}
+	}
+
+	// A synthetic sequence, written from the radio/changer protocol rather than captured from a car,
+	// of a radio selecting the changer just after the bus woke
+	tests := []struct {
+		name   string
+		frame  string
+		want   []gokbus.Packet
+		button string
+		// sleep pretends the bus slept before this frame
+		sleep bool
+	}{
+		{"first poll", "68 03 18 01 72", []gokbus.Packet{announce}, "", false},
+		{"poll", "68 03 18 01 72", []gokbus.Packet{present}, "", false},
+		{"status", "68 05 18 38 00 00 4D", []gokbus.Packet{status(false, 1, 1)}, "", false},
+		{"play", "68 05 18 38 03 00 4E", []gokbus.Packet{status(true, 1, 1)}, "cdc_play", false},
+		{"next track", "68 05 18 38 0A 00 47", []gokbus.Packet{status(true, 1, 2)}, "cdc_next", false},
+		{"previous track", "68 05 18 38 0A 01 46", []gokbus.Packet{status(true, 1, 1)}, "cdc_prev", false},
+		{"previous first track", "68 05 18 38 0A 01 46", []gokbus.Packet{status(true, 1, 1)}, "cdc_prev", false},
+		{"scan forward", "68 05 18 38 04 00 49", []gokbus.Packet{status(true, 1, 1)}, "cdc_next", false},
+		{"scan back", "68 05 18 38 04 01 48", []gokbus.Packet{status(true, 1, 1)}, "cdc_prev", false},
+		{"disc 3", "68 05 18 38 06 03 48", []gokbus.Packet{status(true, 3, 1)}, "cdc_disc_3", false},
+		{"stop", "68 05 18 38 01 00 4C", []gokbus.Packet{status(false, 3, 1)}, "cdc_stop", false},
+		{"poll after wake", "68 03 18 01 72", []gokbus.Packet{announce}, "", true},
+		{"poll after announce", "68 03 18 01 72", []gokbus.Packet{present}, "", false},
+		{"short command", "68 04 18 38 00 4C", nil, "", false},
+	}
+
+	for _, tt := range tests {
+		p, err := decodeFrame(hexFrame(t, tt.frame))
+		if err != nil {
+			t.Fatalf("%s: failed to decode frame: %s", tt.name, err.Error())
+		}
+		if !isCDCPacket(&p) {
+			t.Fatalf("%s: % X isn't for the changer", tt.name, p.Data)
+		}
+		if tt.sleep {
+			cdc.mutex.Lock()
+			cdc.lastPoll = time.Now().Add(-2 * cdcPollGap)
+			cdc.mutex.Unlock()
+		}
+
+		handleCDC(&p)
+
+		if got := queuedResponses(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: responded with %v, want %v", tt.name, got, tt.want)
+		}
+		for _, button := range buttons {
+			key := button.(map[string]interface{})["key"].(string)
+			pressed := core.Settings.Store.GetBool(key)
+			if want := key == "pressed."+tt.button; pressed != want {
+				t.Errorf("%s: %s pressed = %t, want %t", tt.name, key, pressed, want)
+			}
+			core.Settings.Store.Set(key, false)
+		}
+	}
+}
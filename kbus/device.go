@@ -0,0 +1,176 @@
+package kbus
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qcasey/gokbus"
+	"github.com/qcasey/gokbus/pkg/prepackets"
+	"github.com/qcasey/gokbus/pkg/translations"
+	"github.com/qcasey/mdroid/pkg/core"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrDisconnected is returned by writes while the kbus device is down
+var ErrDisconnected = errors.New("kbus device is disconnected")
+
+const (
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = time.Minute
+	// lostCheckInterval is how often a serial device is checked for having been unplugged
+	lostCheckInterval = 5 * time.Second
+)
+
+var (
+	// kbusDevice is nil while there's no device open
+	kbusDevice *gokbus.KBUS
+	// connected is false while the device can't reach the bus, i.e. a TCP bridge is down
+	connected  bool
+	deviceLock sync.RWMutex
+)
+
+// currentDevice returns the device while it can be written to, or nil while it's disconnected
+func currentDevice() *gokbus.KBUS {
+	deviceLock.RLock()
+	defer deviceLock.RUnlock()
+	if !connected {
+		return nil
+	}
+	return kbusDevice
+}
+
+func setDevice(device *gokbus.KBUS) {
+	deviceLock.Lock()
+	kbusDevice = device
+	deviceLock.Unlock()
+}
+
+// setConnected tracks whether the kbus can be reached in kbus.connected
+func setConnected(isConnected bool) {
+	deviceLock.Lock()
+	connected = isConnected
+	deviceLock.Unlock()
+	core.Session.Publish("kbus.connected", isConnected)
+}
+
+// supervise keeps the kbus device connected, reopening it with backoff whenever it's lost
+func supervise(devicePath string) {
+	setConnected(false)
+	backoff := minReconnectBackoff
+	for {
+		device, start, stop, err := openDevice(devicePath)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed to set up KBus with device %s, retrying in %s", devicePath, backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > maxReconnectBackoff {
+				backoff = maxReconnectBackoff
+			}
+			continue
+		}
+		backoff = minReconnectBackoff
+
+		setDevice(device)
+		log.Info().Msgf("Successfully added device %s", devicePath)
+
+		go start()
+		// TCP devices track their own connection, and catch up each time they reconnect
+		if !isTCP(devicePath) {
+			setConnected(true)
+			go onConnect()
+		}
+		readDevice(devicePath, device)
+
+		setConnected(false)
+		setDevice(nil)
+		writes.clear()
+		stop()
+		log.Warn().Msgf("Lost KBus device %s, reconnecting in %s", devicePath, backoff)
+		time.Sleep(backoff)
+	}
+}
+
+// onConnect brings the session up to date once the device is ready
+func onConnect() {
+	WritePackets([]gokbus.Packet{prepackets.RequestIgnitionStatus})
+	WritePackets([]gokbus.Packet{prepackets.RequestVehicleStatus})
+	WritePackets([]gokbus.Packet{prepackets.RequestDoorStatus})
+	WritePackets([]gokbus.Packet{prepackets.TurnOnClownNose})
+	startCDC()
+}
+
+// readDevice interprets packets from the device until it's lost
+func readDevice(devicePath string, device *gokbus.KBUS) {
+	lostCheck := time.NewTicker(lostCheckInterval)
+	defer lostCheck.Stop()
+
+	for {
+		select {
+		case newPacket := <-device.ReadChannel:
+			// Sniffers and button timing see packets in bus order, interpreting them can happen in any order.
+			// Packets without a known meaning can still be matched by source, destination and data
+			packetMeaning, err := translations.GetMeaning(&newPacket)
+			sniff(&newPacket, packetMeaning, err == nil)
+			// Steering wheel buttons are tracked from press to release, rather than by their meaning
+			if isButtonPacket(newPacket.Source, newPacket.Data) {
+				trackButton(newPacket.Data[1], time.Now())
+			}
+			go interpret(&newPacket, packetMeaning, err)
+			if kbusLog != nil {
+				kbusLog.Println(newPacket.Flatten())
+			}
+		case newErr := <-device.ErrorChannel:
+			log.Error().Err(newErr).Msg("Failed to read from kbus device")
+			if isLost(devicePath) {
+				return
+			}
+		case <-lostCheck.C:
+			if isLost(devicePath) {
+				return
+			}
+		}
+	}
+}
+
+// isLost determines if a serial device has gone away, i.e. its USB adapter was unplugged.
+// TCP devices reconnect on their own, and replays can't be lost
+func isLost(devicePath string) bool {
+	if isTCP(devicePath) || isReplay(devicePath) {
+		return false
+	}
+	_, err := os.Stat(devicePath)
+	return err != nil
+}
+
+// openDevice sets up a kbus device, returning the functions that start reading from it and stop it once it's lost.
+// Devices are serial ports, tcp:// addresses or replay: captures
+func openDevice(devicePath string) (*gokbus.KBUS, func(), func(), error) {
+	switch {
+	case isTCP(devicePath):
+		device, start, stop := openTCP(devicePath)
+		return device, start, stop, nil
+
+	case isReplay(devicePath):
+		path := strings.TrimPrefix(devicePath, replayPrefix)
+		if _, err := os.Stat(path); err != nil {
+			return nil, nil, nil, err
+		}
+
+		device := &gokbus.KBUS{
+			ReadChannel:  make(chan gokbus.Packet),
+			WriteChannel: make(chan gokbus.Packet, 16),
+			ErrorChannel: make(chan error),
+		}
+		done := make(chan struct{})
+		return device, func() { replay(device, path, done) }, func() { close(done) }, nil
+	}
+
+	s, err := openSerial(devicePath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return s.device, s.start, s.stop, nil
+}
@@ -0,0 +1,245 @@
+package kbus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/qcasey/gokbus"
+	"github.com/qcasey/mdroid/pkg/core"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultScrollInterval is how long each step of scrolling text stays on a display
+const defaultScrollInterval = 400 * time.Millisecond
+
+// Display is a screen that can show text, and the packet header that writes to it
+type Display struct {
+	Source      string `mapstructure:"source" json:"source"`
+	Destination string `mapstructure:"destination" json:"destination"`
+	Header      string `mapstructure:"header" json:"header"`
+	Width       int    `mapstructure:"width" json:"width"`
+}
+
+// defaultDisplays can be overridden or added to in kbus.displays.<name>
+var defaultDisplays = map[string]Display{
+	"ike":   {Source: "68", Destination: "80", Header: "23 42 30", Width: 20},
+	"mid":   {Source: "68", Destination: "C0", Header: "23 40 20", Width: 20},
+	"radio": {Source: "68", Destination: "3B", Header: "23 62 30", Width: 11},
+}
+
+// transliterations map characters the displays can't show onto ones they can
+var transliterations = map[rune]string{
+	'ä': "ae", 'ö': "oe", 'ü': "ue", 'Ä': "Ae", 'Ö': "Oe", 'Ü': "Ue", 'ß': "ss",
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'å': "a", 'æ': "ae", 'ç': "c",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ì': "i", 'í': "i", 'î': "i", 'ï': "i",
+	'ñ': "n", 'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ø': "o", 'ù': "u", 'ú': "u", 'û': "u", 'ý': "y", 'ÿ': "y",
+	'À': "A", 'Á': "A", 'Â': "A", 'Ã': "A", 'Å': "A", 'Æ': "AE", 'Ç': "C",
+	'È': "E", 'É': "E", 'Ê': "E", 'Ë': "E", 'Ì': "I", 'Í': "I", 'Î': "I", 'Ï': "I",
+	'Ñ': "N", 'Ò': "O", 'Ó': "O", 'Ô': "O", 'Õ': "O", 'Ø': "O", 'Ù': "U", 'Ú': "U", 'Û': "U", 'Ý': "Y",
+	'‘': "'", '’': "'", '“': "\"", '”': "\"", '–': "-", '—': "-", '…': "...",
+}
+
+var (
+	// scrolling holds the cancel function of text scrolling on each display
+	scrolling     = make(map[string]context.CancelFunc)
+	scrollingLock sync.Mutex
+)
+
+// findDisplay looks up a display in settings, falling back to the default displays
+func findDisplay(name string) (Display, error) {
+	name = strings.ToLower(name)
+	display, ok := defaultDisplays[name]
+
+	key := fmt.Sprintf("kbus.displays.%s", name)
+	if core.Settings.Store.IsSet(key) {
+		if err := core.Settings.Store.UnmarshalKey(key, &display); err != nil {
+			return Display{}, err
+		}
+		ok = true
+	}
+	if !ok {
+		return Display{}, fmt.Errorf("Display '%s' not found", name)
+	}
+	if display.Width <= 0 {
+		return Display{}, fmt.Errorf("Display '%s' needs a width", name)
+	}
+	return display, nil
+}
+
+// packet writing exactly one screen of text, padded to the display's width
+func (d Display) packet(text string) (gokbus.Packet, error) {
+	data := fmt.Sprintf("%s %X", d.Header, []byte(fmt.Sprintf("%-*s", d.Width, text)))
+	p, _, err := parseRaw(d.Source, d.Destination, data, "")
+	return p, err
+}
+
+// toDisplayText transliterates text into printable ASCII
+func toDisplayText(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r >= 0x20 && r < 0x7F:
+			b.WriteRune(r)
+		case r == '\n' || r == '\t':
+			b.WriteRune(' ')
+		default:
+			if t, ok := transliterations[r]; ok {
+				b.WriteString(t)
+			} else {
+				b.WriteRune('?')
+			}
+		}
+	}
+	return b.String()
+}
+
+// screens splits text into what's shown at each step, scrolling a character at a time when it's too wide
+func screens(text string, width int) []string {
+	if len(text) <= width {
+		return []string{text}
+	}
+	var steps []string
+	for i := 0; i+width <= len(text); i++ {
+		steps = append(steps, text[i:i+width])
+	}
+	return steps
+}
+
+// ShowText writes text to the named display, scrolling it when it doesn't fit.
+// Returns the text as it will be shown, replacing any text still scrolling on that display
+func ShowText(ctx context.Context, name string, text string) (string, error) {
+	// Display names aren't case sensitive, so neither are the displays text is scrolling on
+	name = strings.ToLower(name)
+	display, err := findDisplay(name)
+	if err != nil {
+		return "", err
+	}
+
+	text = toDisplayText(text)
+	steps := screens(text, display.Width)
+	packets := make([]gokbus.Packet, len(steps))
+	for i, step := range steps {
+		if packets[i], err = display.packet(step); err != nil {
+			return "", err
+		}
+	}
+
+	scrollingLock.Lock()
+	if cancel, ok := scrolling[name]; ok {
+		cancel()
+		delete(scrolling, name)
+	}
+	if len(packets) == 1 {
+		scrollingLock.Unlock()
+		return text, WritePacketsContext(ctx, packets)
+	}
+
+	// Scrolling outlives the request that started it, but keeps its correlation ID
+	scrollCtx := context.Background()
+	if id := core.RequestID(ctx); id != "" {
+		scrollCtx = core.WithRequestID(scrollCtx, id)
+	}
+	scrollCtx, cancel := context.WithCancel(scrollCtx)
+	scrolling[name] = cancel
+	scrollingLock.Unlock()
+
+	go scroll(scrollCtx, name, packets)
+	return text, nil
+}
+
+// scroll writes each step of text in turn, ending back at the start
+func scroll(ctx context.Context, name string, packets []gokbus.Packet) {
+	interval := defaultScrollInterval
+	if core.Settings.Store.IsSet("kbus.display_scroll_interval") {
+		interval = core.Settings.Store.GetDuration("kbus.display_scroll_interval")
+	}
+
+	// Linger on the start and end so they can be read, then return to the start
+	steps := append(packets, packets[0])
+	for i, p := range steps {
+		if err := WritePacketsContext(ctx, []gokbus.Packet{p}); err != nil {
+			core.Log(ctx).Error().Err(err).Msgf("Failed to scroll text on display %s", name)
+			return
+		}
+		if i == len(steps)-1 {
+			break
+		}
+
+		wait := interval
+		if i == 0 || i == len(steps)-2 {
+			wait = 3 * interval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+
+	// Newer text cancels this scroll under the lock, so if it's still running the entry is this one's
+	scrollingLock.Lock()
+	if ctx.Err() == nil {
+		scrolling[name]()
+		delete(scrolling, name)
+	}
+	scrollingLock.Unlock()
+}
+
+// DisplayRequest is the text to show on a display
+type DisplayRequest struct {
+	Text string `json:"text"`
+}
+
+// HandleDisplay shows text on the display named in the path
+func HandleDisplay(w http.ResponseWriter, r *http.Request) {
+	var request DisplayRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		core.WriteNewResponse(&w, r, core.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
+
+	shown, err := ShowText(r.Context(), mux.Vars(r)["target"], request.Text)
+	if err != nil {
+		core.Log(r.Context()).Error().Err(err).Msg("Failed to show text")
+		core.WriteNewResponse(&w, r, core.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
+	core.WriteNewResponse(&w, r, core.JSONResponse{Output: shown, OK: true})
+}
+
+// showNowPlaying writes the playing artist and title to kbus.display_now_playing whenever they change
+func showNowPlaying() {
+	name := core.Settings.Store.GetString("kbus.display_now_playing")
+	if name == "" {
+		return
+	}
+
+	track := make(chan core.Message, 1)
+	core.Session.Subscribe("bluetooth.artist", track)
+	core.Session.Subscribe("bluetooth.title", track)
+
+	log.Info().Msgf("Showing now playing on display %s", name)
+	for range track {
+		artist := core.Session.Store.GetString("bluetooth.artist")
+		title := core.Session.Store.GetString("bluetooth.title")
+		text := title
+		if artist != "" && title != "" {
+			text = fmt.Sprintf("%s - %s", artist, title)
+		} else if title == "" {
+			text = artist
+		}
+		if text == "" {
+			continue
+		}
+
+		if _, err := ShowText(context.Background(), name, text); err != nil {
+			log.Error().Err(err).Msgf("Failed to show now playing on display %s", name)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package kbus
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qcasey/gokbus"
+)
+
+// logTimeFormat is the timestamp written by the kbus log file, with optional microseconds
+const logTimeFormat = "2006/01/02 15:04:05.999999"
+
+// maxFrameLength is the largest length byte written or read, longer than any frame seen on the bus.
+// It also stops a corrupt length leaving the parser waiting on bytes that will never make up a frame
+const maxFrameLength = 0x40
+
+// maxDataLength is the most data a frame carries, as its length also counts the destination and checksum
+const maxDataLength = maxFrameLength - 2
+
+// encodeFrame flattens a packet into the bytes sent on the wire, ending with its checksum
+func encodeFrame(p gokbus.Packet) []byte {
+	frame := append([]byte{p.Source, byte(len(p.Data) + 2), p.Destination}, p.Data...)
+	return append(frame, checksum(frame))
+}
+
+// checksum XORs every byte of a frame
+func checksum(frame []byte) byte {
+	var sum byte
+	for _, b := range frame {
+		sum ^= b
+	}
+	return sum
+}
+
+// parseHex reads bytes written as hex, ignoring spaces and 0x prefixes
+func parseHex(s string) ([]byte, error) {
+	s = strings.ReplaceAll(strings.ReplaceAll(s, "0x", ""), " ", "")
+	return hex.DecodeString(s)
+}
+
+// decodeFrame reads a flattened kbus frame: source, length, destination, data and an optional checksum
+func decodeFrame(frame []byte) (gokbus.Packet, error) {
+	if len(frame) < 3 {
+		return gokbus.Packet{}, fmt.Errorf("frame % X is too short", frame)
+	}
+
+	// Length counts the destination, data and checksum
+	end := len(frame)
+	if int(frame[1])+2 == len(frame) {
+		end--
+	}
+	return gokbus.Packet{
+		Source:      frame[0],
+		Destination: frame[2],
+		Data:        append([]byte{}, frame[3:end]...),
+	}, nil
+}
+
+// frameParser splits a stream of bytes from the bus into packets,
+// skipping ahead a byte at a time when it loses track of where frames start
+type frameParser struct {
+	buffer []byte
+}
+
+// feed bytes read from the bus, returning every complete packet
+func (f *frameParser) feed(data []byte) []gokbus.Packet {
+	f.buffer = append(f.buffer, data...)
+
+	var packets []gokbus.Packet
+	for len(f.buffer) >= 2 {
+		// Length counts the destination, at least one byte of data, and the checksum
+		length := int(f.buffer[1])
+		if length < 3 || length > maxFrameLength {
+			f.buffer = f.buffer[1:]
+			continue
+		}
+		if len(f.buffer) < length+2 {
+			break
+		}
+
+		frame := f.buffer[:length+2]
+		if checksum(frame) != 0 {
+			f.buffer = f.buffer[1:]
+			continue
+		}
+
+		p, err := decodeFrame(frame)
+		f.buffer = f.buffer[length+2:]
+		if err == nil {
+			packets = append(packets, p)
+		}
+	}
+	return packets
+}
+
+// parseLogLine reads a line written to the kbus log file, i.e.
+// 2020/06/01 18:04:05.123456 [128 5 191 19 0 0 63]
+// Frames written as hex (80 05 BF 13 00 00 3F) are read too
+func parseLogLine(line string) (time.Time, gokbus.Packet, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return time.Time{}, gokbus.Packet{}, fmt.Errorf("line %q is too short", line)
+	}
+
+	timestamp, err := time.ParseInLocation(logTimeFormat, fields[0]+" "+fields[1], time.Local)
+	if err != nil {
+		return time.Time{}, gokbus.Packet{}, err
+	}
+
+	raw := strings.Join(fields[2:], " ")
+	base := 16
+	if strings.HasPrefix(raw, "[") {
+		raw = strings.Trim(raw, "[]")
+		base = 10
+	}
+
+	var frame []byte
+	for _, s := range strings.Fields(raw) {
+		b, err := strconv.ParseUint(s, base, 8)
+		if err != nil {
+			return time.Time{}, gokbus.Packet{}, fmt.Errorf("invalid byte %s: %s", s, err.Error())
+		}
+		frame = append(frame, byte(b))
+	}
+
+	p, err := decodeFrame(frame)
+	return timestamp, p, err
+}
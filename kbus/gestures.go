@@ -0,0 +1,142 @@
+package kbus
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/qcasey/mdroid/pkg/core"
+)
+
+const (
+	// mflAddress is the multifunction steering wheel
+	mflAddress = 0x50
+	// mflButtonCommand starts each steering wheel button packet
+	mflButtonCommand = 0x3B
+
+	// Steering wheel button states, sent alongside the button bits
+	mflPressed  = 0x00
+	mflHeld     = 0x10
+	mflReleased = 0x20
+	mflState    = mflHeld | mflReleased
+
+	defaultLongPress   = 700 * time.Millisecond
+	defaultDoublePress = 250 * time.Millisecond
+)
+
+// mflButtons names each steering wheel button bit
+var mflButtons = map[byte]string{
+	0x01: "next",
+	0x08: "prev",
+	0x40: "rt",
+	0x80: "speak",
+}
+
+// buttonState tracks a single steering wheel button between packets
+type buttonState struct {
+	pressedAt time.Time
+	// held is set once the wheel reports the button as held
+	held bool
+	// pending is a short press, waiting to see if a second press follows
+	pending *time.Timer
+}
+
+var (
+	buttonStates = make(map[string]*buttonState)
+	gestureLock  sync.Mutex
+)
+
+// isButtonPacket determines if a packet is a steering wheel button press, hold or release
+func isButtonPacket(sourceAddress byte, data []byte) bool {
+	return sourceAddress == mflAddress && len(data) >= 2 && data[0] == mflButtonCommand
+}
+
+// trackButton turns steering wheel button packets into gestures:
+// short and double presses, long presses on release, and hold while the wheel repeats the button.
+// Packets must be tracked in the order they were received
+func trackButton(status byte, now time.Time) {
+	button, ok := mflButtons[status&^mflState]
+	if !ok {
+		return
+	}
+
+	gestureLock.Lock()
+	defer gestureLock.Unlock()
+
+	b, ok := buttonStates[button]
+	if !ok {
+		b = &buttonState{}
+		buttonStates[button] = b
+	}
+
+	switch status & mflState {
+	case mflPressed:
+		b.pressedAt = now
+		b.held = false
+
+	case mflHeld:
+		b.held = true
+		emitGesture(button, "hold")
+
+	case mflReleased:
+		// The press was missed, don't guess how long it was held
+		if b.pressedAt.IsZero() {
+			return
+		}
+		held := now.Sub(b.pressedAt)
+		b.pressedAt = time.Time{}
+
+		if b.held || held >= gestureSetting("kbus.gestures.long_press", defaultLongPress) {
+			emitGesture(button, "long")
+			return
+		}
+
+		if b.pending != nil {
+			b.pending.Stop()
+			b.pending = nil
+			emitGesture(button, "double")
+			return
+		}
+
+		// Only wait for a second press when it would do something
+		if _, _, ok := findAction(button + "_double"); !ok {
+			emitGesture(button, "short")
+			return
+		}
+
+		var timer *time.Timer
+		timer = time.AfterFunc(gestureSetting("kbus.gestures.double_press", defaultDoublePress), func() {
+			gestureLock.Lock()
+			defer gestureLock.Unlock()
+			// A second press already made this a double
+			if b.pending != timer {
+				return
+			}
+			b.pending = nil
+			emitGesture(button, "short")
+		})
+		b.pending = timer
+	}
+}
+
+func gestureSetting(key string, fallback time.Duration) time.Duration {
+	if core.Settings.Store.IsSet(key) {
+		return core.Settings.Store.GetDuration(key)
+	}
+	return fallback
+}
+
+// emitGesture publishes steering_wheel.<button>, and runs its action.
+// Short presses use the button's own action, others use <button>_<gesture>, i.e. next_long
+func emitGesture(button string, gesture string) {
+	action := button
+	if gesture != "short" {
+		action = fmt.Sprintf("%s_%s", button, gesture)
+	}
+
+	go func() {
+		core.Session.PublishEvent(fmt.Sprintf("steering_wheel.%s", button), gesture)
+		pressButton(context.Background(), action)
+	}()
+}
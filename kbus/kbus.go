@@ -3,10 +3,7 @@ package kbus
 import (
 	"fmt"
 	logger "log"
-	"time"
 
-	"github.com/qcasey/gokbus"
-	"github.com/qcasey/gokbus/pkg/prepackets"
 	"github.com/qcasey/mdroid/pkg/core"
 	"github.com/qcasey/mdroid/pkg/logfile"
 	"github.com/qcasey/mdroid/pkg/server"
@@ -15,9 +12,8 @@ import (
 
 var (
 	// Enabled if the module has been set up
-	Enabled    = false
-	kbusDevice *gokbus.KBUS
-	kbusLog    *logger.Logger
+	Enabled = false
+	kbusLog *logger.Logger
 )
 
 // Start will set up the serial port and ReadSerial goroutine
@@ -35,11 +31,33 @@ func Start(srv *server.Server) {
 	}
 
 	var err error
-	kbusLog = logfile.NewLogFile("/var/log/mdroid/kbus/")
+	table, err = loadTable(core.Settings.Store.GetString("kbus.translation_table"))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load kbus translation table")
+		return
+	}
+
+	// Don't record a replay over again
+	if !isReplay(devicePath) {
+		kbusLog = logfile.NewLogFile("/var/log/mdroid/kbus/")
+	}
+	if kbusLog != nil {
+		// Microseconds keep the timing of replayed captures close to the car's
+		kbusLog.SetFlags(logger.LstdFlags | logger.Lmicroseconds)
+	}
 
 	//
 	// KBus Routes
 	//
+	srv.Router.HandleFunc("/kbus/request/{command}", HandleRequest).Methods("GET")
+	srv.Router.HandleFunc("/kbus/raw", HandleRaw).Methods("POST")
+	srv.Router.HandleFunc("/kbus/macro/{name}", HandleMacro).Methods("GET", "POST")
+	srv.Router.HandleFunc("/kbus/display/{target}", HandleDisplay).Methods("POST")
+	srv.Router.HandleFunc("/kbus/sniff", HandleSniff).Methods("GET")
+	srv.Router.HandleFunc("/kbus/polls", HandlePolls).Methods("GET")
+	srv.Router.HandleFunc("/kbus/unknown", HandleUnknown).Methods("GET")
+	srv.Router.HandleFunc("/kbus/unknown", HandleUnknownReset).Methods("DELETE")
+	srv.Router.HandleFunc("/kbus/unknown/export", HandleUnknownExport).Methods("GET")
 	srv.Router.HandleFunc("/kbus/{src}/{dest}/{data}/{checksum}", HandleWrite).Methods("POST")
 	srv.Router.HandleFunc("/kbus/{src}/{dest}/{data}", HandleWrite).Methods("POST")
 	srv.Router.HandleFunc("/kbus/{command}/{checksum}", HandleWrite).Methods("GET")
@@ -51,45 +69,14 @@ func Start(srv *server.Server) {
 	//
 	srv.Router.HandleFunc("/{device}/{command}", parseCommand()).Methods("GET")
 
-	// Setup devices and read channels
-	kbusDevice, err = gokbus.New(devicePath, 9600)
-	if err != nil {
-		log.Warn().Err(err).Msgf("Failed to set up KBus with device %s", devicePath)
-	}
+	// Keep the device connected, and start the read and write channels
 	Enabled = true
-
-	// Start the read and write channels
-	go kbusDevice.Start()
-
-	go func() {
-		for {
-			select {
-			case newPacket := <-kbusDevice.ReadChannel:
-				go interpret(&newPacket)
-				if kbusLog != nil {
-					kbusLog.Println(newPacket.Flatten())
-				}
-			case newErr := <-kbusDevice.ErrorChannel:
-				log.Error().Err(newErr).Msg("Failed to read from kbus device")
-			}
-		}
-	}()
-
-	log.Info().Msgf("Successfully added device %s", devicePath)
+	go supervise(devicePath)
+	go writes.run()
 
 	// Begin continuous writes
-	go repeatCommand("RequestIgnitionStatus", 10*time.Second)
-	go repeatCommand("RequestLampStatus", 20*time.Second)
-	go repeatCommand("RequestVehicleStatus", 30*time.Second)
-	go repeatCommand("RequestDoorStatus", 55*time.Second)
-	go repeatCommand("RequestOdometer", 45*time.Second)
-	go repeatCommand("RequestTimeStatus", 60*time.Second)
-	go repeatCommand("RequestTemperatureStatus", 120*time.Second)
-
-	go WritePackets([]gokbus.Packet{prepackets.RequestIgnitionStatus})
-	go WritePackets([]gokbus.Packet{prepackets.RequestVehicleStatus})
-	go WritePackets([]gokbus.Packet{prepackets.RequestDoorStatus})
-	go WritePackets([]gokbus.Packet{prepackets.TurnOnClownNose})
+	go poll()
+	go showNowPlaying()
 
 	go func() {
 		// Setup channels for meta window/door status
@@ -136,16 +123,3 @@ func isPositiveRequest(request string) (bool, error) {
 	// Command didn't match any of the above, get out of here
 	return false, fmt.Errorf("Error: %s is an invalid command", request)
 }
-
-// repeatCommand endlessly, helps with request functions
-func repeatCommand(command string, sleepTime time.Duration) {
-	log.Info().Msgf("Running KBUS command %s every %f seconds", command, sleepTime.Seconds())
-	ticker := time.NewTicker(sleepTime)
-	for {
-		// Only push repeated KBUS commands when powered, otherwise the car won't sleep
-		<-ticker.C
-		if core.Session.Store.GetBool("unlock_power") {
-			WriteCommand(command)
-		}
-	}
-}
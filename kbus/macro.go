@@ -0,0 +1,158 @@
+package kbus
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/qcasey/gokbus"
+	"github.com/qcasey/gokbus/pkg/prepackets"
+	"github.com/qcasey/mdroid/pkg/core"
+	"github.com/rs/zerolog/log"
+)
+
+// Macro is a named sequence of kbus writes, set in kbus.macros.<name>
+type Macro struct {
+	// When lists session values that must hold for the macro to run, i.e. doors_locked: false
+	When   map[string]interface{} `mapstructure:"when"`
+	Repeat int                    `mapstructure:"repeat"`
+	Steps  []Step                 `mapstructure:"steps"`
+}
+
+// Step of a macro writes a prepared command or a raw packet, then waits for its delay once it's written
+type Step struct {
+	Command string `mapstructure:"command"`
+	// Raw is a packet as hex, starting with its source and destination, i.e. "68 18 0A 01"
+	Raw    string        `mapstructure:"raw"`
+	Delay  time.Duration `mapstructure:"delay"`
+	Repeat int           `mapstructure:"repeat"`
+
+	packets []gokbus.Packet
+}
+
+// builtinMacros replace commands that take more than one packet, and can be overridden in settings
+var builtinMacros = map[string]Macro{
+	"rollwindowsup":      {Steps: []Step{{packets: prepackets.PopWindowsUp, Repeat: 2}}},
+	"rollwindowsdown":    {Steps: []Step{{packets: prepackets.PopWindowsDown, Repeat: 2}}},
+	"steeringwheelrt":    {Steps: []Step{{packets: prepackets.PressMode}, {packets: prepackets.PressNum6}}},
+	"steeringwheelspeak": {Steps: []Step{{packets: prepackets.PressMode}}},
+}
+
+// macroLock runs one macro at a time, so their packets are never interleaved
+var macroLock sync.Mutex
+
+// findMacro looks up a macro in settings, falling back to the built in macros
+func findMacro(name string) (Macro, bool) {
+	name = strings.ToLower(name)
+	key := fmt.Sprintf("kbus.macros.%s", name)
+	if core.Settings.Store.IsSet(key) {
+		var macro Macro
+		if err := core.Settings.Store.UnmarshalKey(key, &macro); err != nil {
+			log.Error().Err(err).Msgf("Failed to read kbus macro %s", name)
+			return Macro{}, false
+		}
+		return macro, true
+	}
+
+	macro, ok := builtinMacros[name]
+	return macro, ok
+}
+
+// IsMacro determines if there's a macro with the given name
+func IsMacro(name string) bool {
+	_, ok := findMacro(name)
+	return ok
+}
+
+// RunMacro writes every step of the named macro in order, waiting for any other macro to finish first
+func RunMacro(ctx context.Context, name string) error {
+	macro, ok := findMacro(name)
+	if !ok {
+		return fmt.Errorf("Macro '%s' not found", name)
+	}
+
+	// Resolve every step up front, so a bad step doesn't leave the macro half done
+	for i := range macro.Steps {
+		if err := macro.Steps[i].resolve(); err != nil {
+			return fmt.Errorf("Macro '%s' step %d: %s", name, i+1, err.Error())
+		}
+	}
+
+	macroLock.Lock()
+	defer macroLock.Unlock()
+
+	if !core.Session.Matches(macro.When) {
+		return fmt.Errorf("Macro '%s' preconditions not met", name)
+	}
+
+	logger := core.Log(ctx)
+	logger.Info().Msgf("Running kbus macro %s", name)
+	for r := 0; r < repeats(macro.Repeat); r++ {
+		for _, step := range macro.Steps {
+			// Delays count from when the step was written, rather than queued behind other writes
+			for s := 0; s < repeats(step.Repeat); s++ {
+				if err := writePacketsAndWait(ctx, step.packets); err != nil {
+					return fmt.Errorf("Macro '%s' stopped: %s", name, err.Error())
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("Macro '%s' stopped: %s", name, ctx.Err().Error())
+			case <-time.After(step.Delay):
+			}
+		}
+	}
+	logger.Info().Msgf("Finished kbus macro %s", name)
+	return nil
+}
+
+// resolve the packets a step writes
+func (s *Step) resolve() error {
+	if s.packets != nil {
+		return nil
+	}
+
+	switch {
+	case s.Command != "":
+		s.packets = prepackets.RequestToPacket(s.Command)
+		if s.packets == nil {
+			return fmt.Errorf("Command '%s' not found in prepared list of packets", s.Command)
+		}
+	case s.Raw != "":
+		raw, err := parseHex(s.Raw)
+		if err != nil || len(raw) < 3 {
+			return fmt.Errorf("raw packet %s must be hex with a source, destination and data", s.Raw)
+		}
+		p, _, err := parseRaw(fmt.Sprintf("%02X", raw[0]), fmt.Sprintf("%02X", raw[1]), fmt.Sprintf("%X", raw[2:]), "")
+		if err != nil {
+			return err
+		}
+		s.packets = []gokbus.Packet{p}
+	case s.Delay == 0:
+		return fmt.Errorf("step needs a command, raw packet or delay")
+	}
+	return nil
+}
+
+// HandleMacro runs the named macro
+func HandleMacro(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if err := RunMacro(r.Context(), name); err != nil {
+		core.Log(r.Context()).Error().Err(err).Msgf("Failed to run kbus macro %s", name)
+		core.WriteNewResponse(&w, r, core.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
+	core.WriteNewResponse(&w, r, core.JSONResponse{Output: name, OK: true})
+}
+
+// repeats is how many times to run a macro or step, which always runs at least once
+func repeats(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
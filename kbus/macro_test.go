@@ -0,0 +1,145 @@
+package kbus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/qcasey/gokbus"
+	"github.com/qcasey/mdroid/pkg/core"
+)
+
+// deviceWrite is a packet taken from the device, and when
+type deviceWrite struct {
+	packet gokbus.Packet
+	at     time.Time
+}
+
+// startWriting runs a scheduler against a device that records every packet written to it
+func startWriting(t *testing.T) chan deviceWrite {
+	t.Helper()
+	core.Settings = core.NewDatastore(false)
+	core.Session = core.NewDatastore(false)
+	core.Settings.Store.Set("kbus.write_spacing", "20ms")
+
+	device := &gokbus.KBUS{WriteChannel: make(chan gokbus.Packet)}
+	setDevice(device)
+	setConnected(true)
+
+	previous, s := writes, newScheduler()
+	writes = s
+	stopped := make(chan struct{})
+	go func() {
+		s.run()
+		close(stopped)
+	}()
+
+	packets := make(chan deviceWrite, 32)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case p := <-device.WriteChannel:
+				packets <- deviceWrite{packet: p, at: time.Now()}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	t.Cleanup(func() {
+		close(done)
+		s.clear()
+		close(s.quit)
+		<-stopped
+		writes = previous
+		setConnected(false)
+		setDevice(nil)
+	})
+	return packets
+}
+
+// nextWrite waits for the next packet written to the device
+func nextWrite(t *testing.T, packets chan deviceWrite) deviceWrite {
+	t.Helper()
+	select {
+	case w := <-packets:
+		return w
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a kbus write")
+	}
+	return deviceWrite{}
+}
+
+func TestMacroDelayAfterWrite(t *testing.T) {
+	packets := startWriting(t)
+	core.Settings.Store.Set("kbus.macros.test", map[string]interface{}{
+		"steps": []map[string]interface{}{
+			{"raw": "68 18 0A 01", "delay": "100ms"},
+			{"raw": "68 18 0A 02"},
+		},
+	})
+
+	// A backlog of user writes holds up the macro's first step
+	backlog := make([]gokbus.Packet, 5)
+	for i := range backlog {
+		backlog[i] = gokbus.Packet{Source: 0x68, Destination: 0x18, Data: []byte{0x01}}
+	}
+	if err := WritePacketsContext(context.Background(), backlog); err != nil {
+		t.Fatalf("failed to queue the backlog: %s", err.Error())
+	}
+
+	finished := make(chan error, 1)
+	go func() { finished <- RunMacro(context.Background(), "test") }()
+
+	for range backlog {
+		nextWrite(t, packets)
+	}
+	first := nextWrite(t, packets)
+	second := nextWrite(t, packets)
+	if first.packet.Data[1] != 0x01 || second.packet.Data[1] != 0x02 {
+		t.Fatalf("wrote % X then % X, want the macro's steps in order", first.packet.Data, second.packet.Data)
+	}
+	if gap := second.at.Sub(first.at); gap < 100*time.Millisecond {
+		t.Errorf("wrote the second step %s after the first, want at least its 100ms delay", gap)
+	}
+	if err := <-finished; err != nil {
+		t.Errorf("RunMacro() = %v", err)
+	}
+}
+
+func TestMacroCancelled(t *testing.T) {
+	packets := startWriting(t)
+	core.Settings.Store.Set("kbus.macros.test", map[string]interface{}{
+		"steps": []map[string]interface{}{
+			{"raw": "68 18 0A 01", "delay": "10s"},
+			{"raw": "68 18 0A 02"},
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error, 1)
+	go func() { finished <- RunMacro(ctx, "test") }()
+
+	nextWrite(t, packets)
+	cancel()
+	select {
+	case err := <-finished:
+		if err == nil {
+			t.Error("a cancelled macro finished without an error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("a cancelled macro kept waiting out its delay")
+	}
+
+	// The rest of the macro isn't written, and the next macro can run
+	select {
+	case w := <-packets:
+		t.Errorf("wrote % X after the macro was cancelled", w.packet.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if !macroLock.TryLock() {
+		t.Fatal("a cancelled macro kept holding the macro lock")
+	}
+	macroLock.Unlock()
+}
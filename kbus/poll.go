@@ -0,0 +1,166 @@
+package kbus
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/qcasey/mdroid/pkg/core"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// defaultBoostDuration is how long polls run at their boost interval after the car wakes,
+	// unless kbus.poll_boost_duration says otherwise
+	defaultBoostDuration = time.Minute
+	// pollStagger spaces out the first round of polls, so they don't all fire at once
+	pollStagger = time.Second
+)
+
+// Poll is a command requested on an interval, set in kbus.polls
+type Poll struct {
+	Command  string        `mapstructure:"command" json:"command"`
+	Interval time.Duration `mapstructure:"interval" json:"interval"`
+	// Boost is a faster interval used just after the car wakes or the ignition turns on
+	Boost time.Duration `mapstructure:"boost" json:"boost,omitempty"`
+	// When lists session values that must hold to poll, defaulting to unlock_power: true
+	// Polling while the car sleeps would keep it awake
+	When map[string]interface{} `mapstructure:"when" json:"when,omitempty"`
+}
+
+// defaultPolls are used when kbus.polls isn't set
+var defaultPolls = []Poll{
+	{Command: "RequestIgnitionStatus", Interval: 10 * time.Second, Boost: 2 * time.Second},
+	{Command: "RequestLampStatus", Interval: 20 * time.Second},
+	{Command: "RequestVehicleStatus", Interval: 30 * time.Second},
+	{Command: "RequestDoorStatus", Interval: 55 * time.Second, Boost: 5 * time.Second},
+	{Command: "RequestOdometer", Interval: 45 * time.Second},
+	{Command: "RequestTimeStatus", Interval: 60 * time.Second},
+	{Command: "RequestTemperatureStatus", Interval: 120 * time.Second},
+}
+
+// PollStatus is a poll along with when it was last sent
+type PollStatus struct {
+	Poll
+	Active   bool      `json:"active"`
+	Boosted  bool      `json:"boosted"`
+	LastSent time.Time `json:"last_sent,omitempty"`
+}
+
+var (
+	lastPolled = make(map[string]time.Time)
+	// pollsFrom is when the first round of polls started, on startup or the last boost
+	pollsFrom  time.Time
+	boostUntil time.Time
+	pollLock   sync.Mutex
+)
+
+// polls reads the poll list from settings on every use, so it can be changed at runtime
+func polls() []Poll {
+	if !core.Settings.Store.IsSet("kbus.polls") {
+		return defaultPolls
+	}
+
+	var configured []Poll
+	if err := core.Settings.Store.UnmarshalKey("kbus.polls", &configured); err != nil {
+		log.Error().Err(err).Msg("Failed to read kbus.polls, using the default polls")
+		return defaultPolls
+	}
+	return configured
+}
+
+func (p Poll) active() bool {
+	if p.When == nil {
+		return core.Session.Store.GetBool("unlock_power")
+	}
+	return core.Session.Matches(p.When)
+}
+
+// interval to wait between polls, which is shorter while boosted
+func (p Poll) interval(boosted bool) time.Duration {
+	if boosted && p.Boost > 0 {
+		return p.Boost
+	}
+	return p.Interval
+}
+
+// boost polls after the car wakes or the ignition turns on, starting a new round of every poll
+func boost() {
+	duration := defaultBoostDuration
+	if core.Settings.Store.IsSet("kbus.poll_boost_duration") {
+		duration = core.Settings.Store.GetDuration("kbus.poll_boost_duration")
+	}
+
+	pollLock.Lock()
+	boostUntil = time.Now().Add(duration)
+	pollsFrom = time.Now()
+	lastPolled = make(map[string]time.Time)
+	pollLock.Unlock()
+	log.Info().Msgf("Boosting kbus polls for %s", duration)
+}
+
+// poll sends each due poll, checking once a second
+func poll() {
+	power := make(chan core.Message, 1)
+	core.Session.Subscribe("unlock_power", power)
+	core.Session.Subscribe("ignition", power)
+
+	pollLock.Lock()
+	pollsFrom = time.Now()
+	pollLock.Unlock()
+
+	ticker := time.NewTicker(time.Second)
+	for {
+		select {
+		case m := <-power:
+			if on, ok := m.Value.(bool); ok && on {
+				boost()
+			}
+		case <-ticker.C:
+			pollDue()
+		}
+	}
+}
+
+func pollDue() {
+	pollLock.Lock()
+	defer pollLock.Unlock()
+
+	now := time.Now()
+	boosted := now.Before(boostUntil)
+	for i, p := range polls() {
+		if p.Command == "" || p.Interval <= 0 || !p.active() {
+			continue
+		}
+		if last, ok := lastPolled[p.Command]; ok {
+			if now.Sub(last) < p.interval(boosted) {
+				continue
+			}
+		} else if now.Before(pollsFrom.Add(time.Duration(i) * pollStagger)) {
+			continue
+		}
+
+		lastPolled[p.Command] = now
+		if err := pollCommand(p.Command); err != nil {
+			log.Error().Err(err).Msgf("Failed to poll kbus command %s", p.Command)
+		}
+	}
+}
+
+// HandlePolls lists the current polls, and whether they're running
+func HandlePolls(w http.ResponseWriter, r *http.Request) {
+	pollLock.Lock()
+	defer pollLock.Unlock()
+
+	boosted := time.Now().Before(boostUntil)
+	var statuses []PollStatus
+	for _, p := range polls() {
+		statuses = append(statuses, PollStatus{
+			Poll:     p,
+			Active:   p.active(),
+			Boosted:  boosted && p.Boost > 0,
+			LastSent: lastPolled[p.Command],
+		})
+	}
+	core.WriteNewResponse(&w, r, core.JSONResponse{Output: statuses, OK: true})
+}
@@ -0,0 +1,107 @@
+package kbus
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/qcasey/gokbus"
+	"github.com/qcasey/mdroid/pkg/core"
+	"github.com/rs/zerolog/log"
+)
+
+// replayPrefix marks a kbus device as a captured log to be replayed, i.e. replay:/var/log/mdroid/kbus/capture.log
+const replayPrefix = "replay:"
+
+// isReplay determines if the kbus device is a captured log rather than a serial port
+func isReplay(devicePath string) bool {
+	return strings.HasPrefix(devicePath, replayPrefix)
+}
+
+// replay feeds a captured kbus log into the read channel at its original timing,
+// scaled by kbus.replay_speed. A speed of 0 replays as fast as possible. Closing done stops the replay
+func replay(device *gokbus.KBUS, path string, done chan struct{}) {
+	// There's no car to write to, swallow anything sent its way
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case p := <-device.WriteChannel:
+				log.Debug().Msgf("Replay ignored written kbus packet %02X -> %02X: % X", p.Source, p.Destination, p.Data)
+			}
+		}
+	}()
+
+	speed := 1.0
+	if core.Settings.Store.IsSet("kbus.replay_speed") {
+		speed = core.Settings.Store.GetFloat64("kbus.replay_speed")
+	}
+
+	for {
+		if !replayFile(device, path, speed, done) {
+			return
+		}
+		if !core.Settings.Store.GetBool("kbus.replay_loop") {
+			log.Info().Msgf("Finished replaying kbus log %s", path)
+			return
+		}
+	}
+}
+
+// replayFile feeds the log into the read channel once, returning false if the replay was stopped
+func replayFile(device *gokbus.KBUS, path string, speed float64, done chan struct{}) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return send(device.ErrorChannel, err, done)
+	}
+	defer f.Close()
+
+	log.Info().Msgf("Replaying kbus log %s at %.2fx speed", path, speed)
+
+	var last time.Time
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		timestamp, p, err := parseLogLine(line)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Skipping unreadable line in kbus log %s", path)
+			continue
+		}
+
+		if speed > 0 && !last.IsZero() && timestamp.After(last) {
+			select {
+			case <-done:
+				return false
+			case <-time.After(time.Duration(float64(timestamp.Sub(last)) / speed)):
+			}
+		}
+		last = timestamp
+
+		select {
+		case <-done:
+			return false
+		case device.ReadChannel <- p:
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return send(device.ErrorChannel, err, done)
+	}
+	return true
+}
+
+// send an error to the device, returning false if the replay was stopped first
+func send(errs chan error, err error, done chan struct{}) bool {
+	select {
+	case errs <- err:
+		return true
+	case <-done:
+		return false
+	}
+}
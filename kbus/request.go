@@ -0,0 +1,168 @@
+package kbus
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/qcasey/gokbus"
+	"github.com/qcasey/gokbus/pkg/prepackets"
+	"github.com/qcasey/gokbus/pkg/translations"
+	"github.com/qcasey/mdroid/pkg/core"
+)
+
+const (
+	// defaultRequestTimeout is how long to wait for a reply when a request doesn't say
+	defaultRequestTimeout = 2 * time.Second
+	// globalAddress is the destination of status messages every device reads
+	globalAddress = 0xBF
+)
+
+// reply describes the packet sent back to a request
+type reply struct {
+	meaning translations.PacketMessageMeaning
+	// global replies are sent to every device, rather than back to the requester
+	global bool
+}
+
+// replies maps each request command to the packet sent back
+var replies = map[string]reply{
+	"RequestDoorStatus":        {meaning: translations.WindowDoorMessage, global: true},
+	"RequestIgnitionStatus":    {meaning: translations.IgnitionStatus, global: true},
+	"RequestOdometer":          {meaning: translations.OdometerStatus, global: true},
+	"RequestTemperatureStatus": {meaning: translations.TemperatureStatus, global: true},
+	"RequestVehicleStatus":     {meaning: translations.VehicleStatus, global: true},
+}
+
+// waiter is a request waiting on its reply
+type waiter struct {
+	meaning translations.PacketMessageMeaning
+	// source of the reply is the destination of the request
+	source byte
+	// destinations the reply may be sent to, the requester and any broadcast it's sent as
+	destinations []byte
+	reply        chan gokbus.Packet
+}
+
+// matches determines if a packet is the reply being waited on
+func (w *waiter) matches(p *gokbus.Packet, m translations.PacketMessageMeaning) bool {
+	if w.meaning != m || w.source != p.Source {
+		return false
+	}
+	for _, d := range w.destinations {
+		if d == p.Destination {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	waiters     []*waiter
+	waitersLock sync.Mutex
+)
+
+// deliver a packet to every request waiting for it
+func deliver(p *gokbus.Packet, m translations.PacketMessageMeaning) {
+	waitersLock.Lock()
+	defer waitersLock.Unlock()
+
+	remaining := waiters[:0]
+	for _, w := range waiters {
+		if w.matches(p, m) {
+			w.reply <- *p
+			continue
+		}
+		remaining = append(remaining, w)
+	}
+	waiters = remaining
+}
+
+func addWaiter(w *waiter) {
+	waitersLock.Lock()
+	defer waitersLock.Unlock()
+	waiters = append(waiters, w)
+}
+
+func removeWaiter(w *waiter) {
+	waitersLock.Lock()
+	defer waitersLock.Unlock()
+	for i := range waiters {
+		if waiters[i] == w {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// Request sends a prepared request command, and waits for its reply to be decoded
+func Request(ctx context.Context, command string, timeout time.Duration) ([]Value, error) {
+	expected, ok := replies[command]
+	if !ok {
+		return nil, fmt.Errorf("Command '%s' has no known reply", command)
+	}
+	packets := prepackets.RequestToPacket(command)
+	if len(packets) == 0 {
+		return nil, fmt.Errorf("Command '%s' not found in prepared list of packets", command)
+	}
+
+	// Register before writing, so a quick reply isn't missed
+	request := packets[len(packets)-1]
+	w := &waiter{
+		meaning:      expected.meaning,
+		source:       request.Destination,
+		destinations: []byte{request.Source},
+		reply:        make(chan gokbus.Packet, 1),
+	}
+	if expected.global {
+		w.destinations = append(w.destinations, globalAddress)
+	}
+	addWaiter(w)
+	defer removeWaiter(w)
+
+	if err := WritePacketsContext(ctx, packets); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	select {
+	case p := <-w.reply:
+		core.Log(ctx).Debug().Msgf("Received reply to kbus request %s: % X", command, p.Data)
+		return decode(table, &p, expected.meaning, true), nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("Timed out after %s waiting for a reply to %s", timeout, command)
+	}
+}
+
+// HandleRequest sends a request command and responds with the decoded reply
+func HandleRequest(w http.ResponseWriter, r *http.Request) {
+	command := mux.Vars(r)["command"]
+
+	timeout := defaultRequestTimeout
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		parsed, err := time.ParseDuration(t)
+		if err != nil {
+			core.WriteNewResponse(&w, r, core.JSONResponse{Output: fmt.Sprintf("Invalid timeout %s", t), OK: false})
+			return
+		}
+		timeout = parsed
+	}
+
+	values, err := Request(r.Context(), command, timeout)
+	if err != nil {
+		core.Log(r.Context()).Error().Err(err).Msgf("Failed kbus request %s", command)
+		core.WriteNewResponse(&w, r, core.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
+
+	output := make(map[string]interface{}, len(values))
+	for _, v := range values {
+		output[v.Topic] = v.Value
+	}
+	core.WriteNewResponse(&w, r, core.JSONResponse{Output: output, OK: true})
+}
@@ -0,0 +1,51 @@
+package kbus
+
+import (
+	"testing"
+
+	"github.com/qcasey/gokbus"
+	"github.com/qcasey/gokbus/pkg/translations"
+)
+
+func TestDeliver(t *testing.T) {
+	// Waiting on the odometer from the IKE, as requested by the radio
+	w := &waiter{
+		meaning:      translations.OdometerStatus,
+		source:       0x80,
+		destinations: []byte{0x68, globalAddress},
+		reply:        make(chan gokbus.Packet, 1),
+	}
+
+	tests := []struct {
+		name      string
+		p         gokbus.Packet
+		m         translations.PacketMessageMeaning
+		delivered bool
+	}{
+		{"other meaning", gokbus.Packet{Source: 0x80, Destination: 0x68, Data: []byte{0x19}}, translations.TemperatureStatus, false},
+		{"other source", gokbus.Packet{Source: 0x3F, Destination: 0x68, Data: []byte{0x17}}, translations.OdometerStatus, false},
+		{"reply to another device", gokbus.Packet{Source: 0x80, Destination: 0x3B, Data: []byte{0x17}}, translations.OdometerStatus, false},
+		{"local broadcast", gokbus.Packet{Source: 0x80, Destination: 0xFF, Data: []byte{0x17}}, translations.OdometerStatus, false},
+		{"reply to the requester", gokbus.Packet{Source: 0x80, Destination: 0x68, Data: []byte{0x17}}, translations.OdometerStatus, true},
+		{"global reply", gokbus.Packet{Source: 0x80, Destination: globalAddress, Data: []byte{0x17}}, translations.OdometerStatus, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addWaiter(w)
+			defer removeWaiter(w)
+
+			deliver(&tt.p, tt.m)
+			select {
+			case <-w.reply:
+				if !tt.delivered {
+					t.Errorf("delivered %02X -> %02X to the waiter", tt.p.Source, tt.p.Destination)
+				}
+			default:
+				if tt.delivered {
+					t.Errorf("didn't deliver %02X -> %02X to the waiter", tt.p.Source, tt.p.Destination)
+				}
+			}
+		})
+	}
+}
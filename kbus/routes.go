@@ -1,12 +1,22 @@
 package kbus
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 
 	"github.com/gorilla/mux"
 	"github.com/qcasey/mdroid/pkg/core"
 )
 
+// RawPacket is a kbus packet written as hex
+type RawPacket struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Data        string `json:"data"`
+	Checksum    string `json:"checksum,omitempty"`
+}
+
 // HandleWrite handles incoming requests to the kbus program, will add routines to the queue
 func HandleWrite(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
@@ -15,10 +25,14 @@ func HandleWrite(w http.ResponseWriter, r *http.Request) {
 	dest, destOK := params["dest"]
 	data, dataOK := params["data"]
 
-	if srcOK && destOK && dataOK && len(src) == 2 && len(dest) == 2 && len(data) > 0 {
-		WriteData(src, dest, data)
+	if srcOK && destOK && dataOK {
+		writeRaw(w, r, RawPacket{Source: src, Destination: dest, Data: data, Checksum: params["checksum"]})
+		return
 	} else if params["command"] != "" {
-		WriteCommand(params["command"])
+		if err := WriteCommandContext(r.Context(), params["command"]); err != nil {
+			core.WriteNewResponse(&w, r, core.JSONResponse{Output: err.Error(), OK: false})
+			return
+		}
 	} else {
 		core.WriteNewResponse(&w, r, core.JSONResponse{Output: "Invalid command", OK: false})
 		return
@@ -26,3 +40,24 @@ func HandleWrite(w http.ResponseWriter, r *http.Request) {
 
 	core.WriteNewResponse(&w, r, core.JSONResponse{Output: "OK", OK: true})
 }
+
+// HandleRaw writes a packet given as hex in the JSON body
+func HandleRaw(w http.ResponseWriter, r *http.Request) {
+	var raw RawPacket
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		core.WriteNewResponse(&w, r, core.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
+	writeRaw(w, r, raw)
+}
+
+// writeRaw queues a raw packet, responding with the exact frame written
+func writeRaw(w http.ResponseWriter, r *http.Request, raw RawPacket) {
+	frame, err := WriteRaw(r.Context(), raw.Source, raw.Destination, raw.Data, raw.Checksum)
+	if err != nil {
+		core.Log(r.Context()).Error().Err(err).Msg("Rejected raw kbus packet")
+		core.WriteNewResponse(&w, r, core.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
+	core.WriteNewResponse(&w, r, core.JSONResponse{Output: fmt.Sprintf("% X", frame), OK: true})
+}
@@ -0,0 +1,207 @@
+package kbus
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/qcasey/gokbus"
+	"github.com/qcasey/mdroid/pkg/core"
+)
+
+const (
+	// defaultWriteSpacing leaves room between packets on the 9600 baud bus, unless kbus.write_spacing says otherwise
+	defaultWriteSpacing = 25 * time.Millisecond
+	// writeTimeout is how long the device has to accept a packet before it's dropped
+	writeTimeout = time.Second
+	// depthInterval limits how often KBUS_QUEUE_DEPTH is published while the queue is busy
+	depthInterval = time.Second
+)
+
+// priority of a kbus write, where lower values are written first
+type priority int
+
+const (
+	// priorityResponse writes answer other modules on the bus, which expect a timely reply
+	priorityResponse priority = iota
+	// priorityUser writes are commands asked for by people and other modules
+	priorityUser
+	// priorityPoll writes request state the car wouldn't otherwise report
+	priorityPoll
+	numPriorities
+)
+
+type queuedPacket struct {
+	packet gokbus.Packet
+	// ctx carries the correlation ID of the request that queued the packet, it's only used for logging
+	ctx context.Context
+	// key identifies identical polls, so they're only queued once
+	key string
+	// written is told once the packet is written or dropped, when something's waiting on it
+	written chan<- error
+}
+
+// done tells anything waiting on the packet how its write went
+func (q queuedPacket) done(err error) {
+	if q.written != nil {
+		q.written <- err
+	}
+}
+
+// scheduler orders kbus writes by priority, spacing them out on the bus
+type scheduler struct {
+	mutex   sync.Mutex
+	queues  [numPriorities][]queuedPacket
+	pending map[string]bool
+	ready   chan struct{}
+	// quit ends run once it's waiting on packets to write
+	quit chan struct{}
+
+	// The last published queue depth, and when it was published
+	depthLock     sync.Mutex
+	lastDepth     int
+	lastDepthTime time.Time
+}
+
+var writes = newScheduler()
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		pending: make(map[string]bool),
+		ready:   make(chan struct{}, 1),
+		quit:    make(chan struct{}),
+	}
+}
+
+// push packets onto the queue for their priority. Polls already waiting to be written are skipped
+func (s *scheduler) push(ctx context.Context, p priority, packets []gokbus.Packet) {
+	s.enqueue(ctx, p, packets, nil)
+}
+
+// pushTracked pushes packets like push, returning a channel told as each one is written or dropped
+func (s *scheduler) pushTracked(ctx context.Context, p priority, packets []gokbus.Packet) <-chan error {
+	written := make(chan error, len(packets))
+	s.enqueue(ctx, p, packets, written)
+	return written
+}
+
+func (s *scheduler) enqueue(ctx context.Context, p priority, packets []gokbus.Packet, written chan<- error) {
+	s.mutex.Lock()
+	for _, packet := range packets {
+		q := queuedPacket{packet: packet, ctx: ctx, written: written}
+		if p == priorityPoll {
+			q.key = fmt.Sprintf("%02X%02X%02X", packet.Source, packet.Destination, packet.Data)
+			if s.pending[q.key] {
+				q.done(nil)
+				continue
+			}
+			s.pending[q.key] = true
+		}
+		s.queues[p] = append(s.queues[p], q)
+	}
+	depth := s.depth()
+	s.mutex.Unlock()
+
+	s.publishDepth(depth)
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop the next packet to write, from the highest priority queue
+func (s *scheduler) pop() (queuedPacket, int, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for p := range s.queues {
+		if len(s.queues[p]) == 0 {
+			continue
+		}
+		q := s.queues[p][0]
+		s.queues[p] = s.queues[p][1:]
+		if q.key != "" {
+			delete(s.pending, q.key)
+		}
+		return q, s.depth(), true
+	}
+	return queuedPacket{}, 0, false
+}
+
+// depth is the number of packets waiting to be written. Expects the mutex to be held
+func (s *scheduler) depth() int {
+	depth := 0
+	for _, queue := range s.queues {
+		depth += len(queue)
+	}
+	return depth
+}
+
+// clear every queued packet, when they can no longer be written
+func (s *scheduler) clear() {
+	s.mutex.Lock()
+	for p := range s.queues {
+		for _, q := range s.queues[p] {
+			q.done(ErrDisconnected)
+		}
+		s.queues[p] = nil
+	}
+	s.pending = make(map[string]bool)
+	s.mutex.Unlock()
+
+	s.publishDepth(0)
+}
+
+// publishDepth publishes KBUS_QUEUE_DEPTH when it changes, at most once every depthInterval.
+// An empty queue is always published, so the session settles on the right depth
+func (s *scheduler) publishDepth(depth int) {
+	s.depthLock.Lock()
+	defer s.depthLock.Unlock()
+
+	if depth == s.lastDepth || (depth != 0 && time.Since(s.lastDepthTime) < depthInterval) {
+		return
+	}
+	s.lastDepth = depth
+	s.lastDepthTime = time.Now()
+	core.Session.Publish("KBUS_QUEUE_DEPTH", depth)
+}
+
+// run writes queued packets to the current device one at a time, until quit is closed
+func (s *scheduler) run() {
+	for {
+		q, depth, ok := s.pop()
+		if !ok {
+			select {
+			case <-s.ready:
+			case <-s.quit:
+				return
+			}
+			continue
+		}
+
+		device := currentDevice()
+		if device == nil {
+			core.Log(q.ctx).Debug().Msgf("Dropped kbus packet % X, the device is disconnected", q.packet.Data)
+			q.done(ErrDisconnected)
+			continue
+		}
+
+		// A device that stopped taking writes shouldn't hold up the queue forever
+		select {
+		case device.WriteChannel <- q.packet:
+			core.Log(q.ctx).Debug().Msgf("Wrote kbus packet %02X -> %02X: % X", q.packet.Source, q.packet.Destination, q.packet.Data)
+			q.done(nil)
+		case <-time.After(writeTimeout):
+			core.Log(q.ctx).Warn().Msgf("Dropped kbus packet % X, the device didn't accept it within %s", q.packet.Data, writeTimeout)
+			q.done(fmt.Errorf("kbus device didn't accept packet % X within %s", q.packet.Data, writeTimeout))
+		}
+		s.publishDepth(depth)
+
+		spacing := defaultWriteSpacing
+		if core.Settings.Store.IsSet("kbus.write_spacing") {
+			spacing = core.Settings.Store.GetDuration("kbus.write_spacing")
+		}
+		time.Sleep(spacing)
+	}
+}
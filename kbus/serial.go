@@ -0,0 +1,139 @@
+package kbus
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/qcasey/gokbus"
+	"github.com/tarm/serial"
+)
+
+const (
+	// kbusBaud is the bus speed, sent with even parity
+	kbusBaud = 9600
+	// serialReadTimeout is how long a read waits on a quiet bus before checking if the device was stopped
+	serialReadTimeout = 100 * time.Millisecond
+	// serialErrorBackoff stops a failing port from being read in a tight loop
+	serialErrorBackoff = time.Second
+)
+
+// serialDevice carries raw kbus frames over a serial port, i.e. a USB adapter.
+// The port is owned here rather than by gokbus, so it can be closed once the adapter is lost
+type serialDevice struct {
+	name   string
+	device *gokbus.KBUS
+	port   io.ReadWriteCloser
+	// done is closed once the device is stopped
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// openSerial opens a kbus device on a serial port, with the same channels as gokbus would give it
+func openSerial(devicePath string) (*serialDevice, error) {
+	port, err := serial.OpenPort(&serial.Config{
+		Name:        devicePath,
+		Baud:        kbusBaud,
+		Parity:      serial.ParityEven,
+		ReadTimeout: serialReadTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newSerialDevice(devicePath, port), nil
+}
+
+func newSerialDevice(name string, port io.ReadWriteCloser) *serialDevice {
+	return &serialDevice{
+		name: name,
+		device: &gokbus.KBUS{
+			ReadChannel:  make(chan gokbus.Packet),
+			WriteChannel: make(chan gokbus.Packet, 16),
+			ErrorChannel: make(chan error),
+		},
+		port: port,
+		done: make(chan struct{}),
+	}
+}
+
+// start reads from the port until the device is stopped
+func (s *serialDevice) start() {
+	go s.write()
+
+	var parser frameParser
+	buffer := make([]byte, 256)
+	for {
+		started := time.Now()
+		n, err := s.port.Read(buffer)
+		if s.stopped() {
+			return
+		}
+		// Reads time out on a quiet bus, which comes back as nothing read.
+		// A port that hung up does the same straight away, so it's read no faster than a quiet one
+		if n == 0 && err == io.EOF {
+			select {
+			case <-s.done:
+				return
+			case <-time.After(serialReadTimeout - time.Since(started)):
+			}
+			continue
+		}
+		if err != nil && err != io.EOF {
+			s.report(fmt.Errorf("failed to read from %s: %s", s.name, err.Error()))
+			select {
+			case <-s.done:
+				return
+			case <-time.After(serialErrorBackoff):
+			}
+			continue
+		}
+
+		for _, p := range parser.feed(buffer[:n]) {
+			select {
+			case s.device.ReadChannel <- p:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
+// stop closes the port and ends the device's goroutines
+func (s *serialDevice) stop() {
+	s.stopOnce.Do(func() {
+		close(s.done)
+		s.port.Close()
+	})
+}
+
+func (s *serialDevice) stopped() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// report an error to the device, unless it's been stopped and nothing reads them anymore
+func (s *serialDevice) report(err error) {
+	select {
+	case s.device.ErrorChannel <- err:
+	case <-s.done:
+	}
+}
+
+// write every packet from the write channel to the port
+func (s *serialDevice) write() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case p := <-s.device.WriteChannel:
+			if _, err := s.port.Write(encodeFrame(p)); err != nil {
+				s.report(fmt.Errorf("failed to write to %s: %s", s.name, err.Error()))
+			}
+		}
+	}
+}
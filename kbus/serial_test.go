@@ -0,0 +1,128 @@
+package kbus
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qcasey/gokbus"
+)
+
+var errUnplugged = errors.New("input/output error")
+
+// fakePort reads whatever's sent on reads, failing like an unplugged adapter once reads is closed
+type fakePort struct {
+	reads  chan []byte
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newFakePort() *fakePort {
+	return &fakePort{reads: make(chan []byte), closed: make(chan struct{})}
+}
+
+func (f *fakePort) Read(b []byte) (int, error) {
+	select {
+	case data, ok := <-f.reads:
+		if !ok {
+			return 0, errUnplugged
+		}
+		return copy(b, data), nil
+	case <-f.closed:
+		return 0, os.ErrClosed
+	}
+}
+
+func (f *fakePort) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (f *fakePort) Close() error {
+	f.once.Do(func() { close(f.closed) })
+	return nil
+}
+
+func (f *fakePort) isClosed() bool {
+	select {
+	case <-f.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitFor fails the test unless done is closed in time
+func waitFor(t *testing.T, done chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal(what)
+	}
+}
+
+func TestSerialDeviceLost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kbus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	devicePath := filepath.Join(dir, "ttyUSB0")
+	ioutil.WriteFile(devicePath, nil, 0644)
+
+	port := newFakePort()
+	s := newSerialDevice(devicePath, port)
+	exited := make(chan struct{})
+	go func() {
+		s.start()
+		close(exited)
+	}()
+	defer s.stop()
+
+	port.reads <- []byte{0x80, 0x05, 0xBF, 0x13, 0x03, 0xB1, 0x9B}
+	want := gokbus.Packet{Source: 0x80, Destination: 0xBF, Data: []byte{0x13, 0x03, 0xB1}}
+	if p := receivePacket(t, s.device); !reflect.DeepEqual(p, want) {
+		t.Errorf("read %v, want %v", p, want)
+	}
+
+	// Unplugging the adapter removes its device and fails reads, which ends reading from it
+	read := make(chan struct{})
+	go func() {
+		readDevice(devicePath, s.device)
+		close(read)
+	}()
+	os.Remove(devicePath)
+	close(port.reads)
+	waitFor(t, read, "kept reading from a lost device")
+
+	// Stopping the lost device closes its port, and its reader exits
+	s.stop()
+	waitFor(t, exited, "the reader kept running after the device was lost")
+	if !port.isClosed() {
+		t.Error("the port of a lost device wasn't closed")
+	}
+}
+
+func TestSerialDeviceStop(t *testing.T) {
+	port := newFakePort()
+	s := newSerialDevice("ttyUSB0", port)
+	exited := make(chan struct{})
+	go func() {
+		s.start()
+		close(exited)
+	}()
+
+	// Nothing reads the frame, as happens once a device is lost, so the reader is left waiting to hand it over
+	port.reads <- []byte{0x68, 0x03, 0x18, 0x01, 0x72}
+	s.stop()
+	waitFor(t, exited, "the reader stayed blocked after the device was stopped")
+	if !port.isClosed() {
+		t.Error("the port wasn't closed")
+	}
+	s.stop()
+}
@@ -0,0 +1,175 @@
+package kbus
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/qcasey/gokbus"
+	"github.com/qcasey/gokbus/pkg/translations"
+	"github.com/qcasey/mdroid/pkg/core"
+)
+
+// deviceNames are the common names of kbus device addresses
+var deviceNames = map[byte]string{
+	0x00: "GM",
+	0x08: "SHD",
+	0x18: "CDC",
+	0x24: "HKM",
+	0x28: "FUH",
+	0x30: "CCM",
+	0x3B: "NAV",
+	0x3F: "DIA",
+	0x43: "MENU",
+	0x44: "EWS",
+	0x46: "CID",
+	0x47: "FMBT",
+	0x50: "MFL",
+	0x51: "MM0",
+	0x5B: "IHK",
+	0x60: "PDC",
+	0x68: "RAD",
+	0x6A: "DSP",
+	0x72: "SM0",
+	0x73: "SDRS",
+	0x76: "CDCD",
+	0x7F: "NAVE",
+	0x80: "IKE",
+	0x9B: "MM1",
+	0x9C: "MM2",
+	0xA0: "FMID",
+	0xA4: "ABM",
+	0xA8: "KAM",
+	0xAC: "ASP",
+	0xB0: "SES",
+	0xBB: "NAVJ",
+	0xBF: "GLO",
+	0xC0: "MID",
+	0xC8: "TEL",
+	0xCA: "TCU",
+	0xD0: "LCM",
+	0xDA: "SM1",
+	0xE0: "IRIS",
+	0xE7: "ANZV",
+	0xE8: "RLS",
+	0xED: "VM",
+	0xF0: "BMBT",
+	0xFF: "LOC",
+}
+
+// deviceName of a kbus address, falling back to its hex
+func deviceName(address byte) string {
+	if name, ok := deviceNames[address]; ok {
+		return name
+	}
+	return fmt.Sprintf("%02X", address)
+}
+
+// meaningName is the name of a translation, as used in translation tables
+func meaningName(m translations.PacketMessageMeaning) string {
+	for name, meaning := range meanings {
+		if meaning == m {
+			return name
+		}
+	}
+	return fmt.Sprintf("%d", m)
+}
+
+// Sniffed is a packet read from the kbus, as shown to sniffers
+type Sniffed struct {
+	Time            time.Time `json:"time"`
+	Source          string    `json:"source"`
+	SourceName      string    `json:"source_name"`
+	Destination     string    `json:"destination"`
+	DestinationName string    `json:"destination_name"`
+	Data            string    `json:"data"`
+	Meaning         string    `json:"meaning,omitempty"`
+
+	packet gokbus.Packet
+	known  bool
+}
+
+var (
+	sniffers     = make(map[chan Sniffed]bool)
+	sniffersLock sync.Mutex
+)
+
+// sniff hands a packet read from the kbus to every open sniffer, dropping it for sniffers that fall behind
+func sniff(p *gokbus.Packet, m translations.PacketMessageMeaning, known bool) {
+	sniffersLock.Lock()
+	defer sniffersLock.Unlock()
+	if len(sniffers) == 0 {
+		return
+	}
+
+	s := Sniffed{
+		Time:            time.Now(),
+		Source:          fmt.Sprintf("%02X", p.Source),
+		SourceName:      deviceName(p.Source),
+		Destination:     fmt.Sprintf("%02X", p.Destination),
+		DestinationName: deviceName(p.Destination),
+		Data:            fmt.Sprintf("% X", p.Data),
+		packet:          *p,
+		known:           known,
+	}
+	if known {
+		s.Meaning = meaningName(m)
+	}
+
+	for sniffer := range sniffers {
+		select {
+		case sniffer <- s:
+		default:
+		}
+	}
+}
+
+// HandleSniff streams packets read from the kbus as server-sent events.
+// Filter with ?src=, ?dest= and ?prefix= as hex, and ?unknown=true for packets without a known meaning
+func HandleSniff(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := Rule{Source: query.Get("src"), Destination: query.Get("dest"), Prefix: query.Get("prefix")}
+	if err := filter.compile(); err != nil {
+		core.WriteNewResponse(&w, r, core.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
+	unknownOnly := query.Get("unknown") == "true" || query.Get("unknown") == "1"
+
+	stream, err := core.NewEventStream(w)
+	if err != nil {
+		core.Log(r.Context()).Error().Err(err).Msg("Failed to open kbus sniffer")
+		core.WriteNewResponse(&w, r, core.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
+
+	packets := make(chan Sniffed, 100)
+	sniffersLock.Lock()
+	sniffers[packets] = true
+	sniffersLock.Unlock()
+	defer func() {
+		sniffersLock.Lock()
+		delete(sniffers, packets)
+		sniffersLock.Unlock()
+	}()
+
+	core.Log(r.Context()).Info().Msgf("Sniffing kbus for %s", r.RemoteAddr)
+	for {
+		select {
+		case <-r.Context().Done():
+			core.Log(r.Context()).Info().Msgf("Closed kbus sniffer for %s", r.RemoteAddr)
+			return
+		case s := <-packets:
+			if unknownOnly && s.known {
+				continue
+			}
+			if !filter.matches(&s.packet, 0, false) {
+				continue
+			}
+			if err := stream.Send("packet", s); err != nil {
+				core.Log(r.Context()).Error().Err(err).Msg("Failed to write kbus sniffer stream")
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package kbus
+
+import (
+	_ "embed" // Default translation table
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/bits"
+	"strings"
+
+	"github.com/qcasey/gokbus"
+	"github.com/qcasey/gokbus/pkg/translations"
+	"gopkg.in/yaml.v2"
+)
+
+//go:embed table.yaml
+var defaultTable []byte
+
+// table holds the rules used to decode every packet read from the kbus
+var table []Rule
+
+// maxFieldLength is the most bytes a uint field reads, as it's decoded into 64 bits
+const maxFieldLength = 8
+
+// meanings maps the names used in translation tables to gokbus translations
+var meanings = map[string]translations.PacketMessageMeaning{
+	"IgnitionOff":                  translations.IgnitionOff,
+	"KeyIn":                        translations.KeyIn,
+	"KeyOut":                       translations.KeyOut,
+	"KeyNotDetected":               translations.KeyNotDetected,
+	"KeyDetected":                  translations.KeyDetected,
+	"TopClosed":                    translations.TopClosed,
+	"TopOpen":                      translations.TopOpen,
+	"CarUnlocked":                  translations.CarUnlocked,
+	"CarLocked":                    translations.CarLocked,
+	"PassengerDoorLocked":          translations.PassengerDoorLocked,
+	"DriverDoorLocked":             translations.DriverDoorLocked,
+	"AuxHeatingOff":                translations.AuxHeatingOff,
+	"SeatMemory1":                  translations.SeatMemory1,
+	"SeatMemory2":                  translations.SeatMemory2,
+	"SeatMemory3":                  translations.SeatMemory3,
+	"SeatMemoryAny":                translations.SeatMemoryAny,
+	"SteeringWheelNextPressed":     translations.SteeringWheelNextPressed,
+	"SteeringWheelPreviousPressed": translations.SteeringWheelPreviousPressed,
+	"SteeringWheelRTPressed":       translations.SteeringWheelRTPressed,
+	"SteeringWheelSpeakPressed":    translations.SteeringWheelSpeakPressed,
+	"VehicleStatus":                translations.VehicleStatus,
+	"WindowDoorMessage":            translations.WindowDoorMessage,
+	"RainLightSensorStatus":        translations.RainLightSensorStatus,
+	"SensorStatus":                 translations.SensorStatus,
+	"TemperatureStatus":            translations.TemperatureStatus,
+	"ClimateControl":               translations.ClimateControl,
+	"Diagnostic":                   translations.Diagnostic,
+	"IgnitionStatus":               translations.IgnitionStatus,
+	"OdometerStatus":               translations.OdometerStatus,
+	"SpeedRPMStatus":               translations.SpeedRPMStatus,
+	"RangeStatus":                  translations.RangeStatus,
+	"IkeStatus":                    translations.IkeStatus,
+}
+
+// Rule decodes the packets it matches into session values
+type Rule struct {
+	Name        string  `yaml:"name" json:"name"`
+	Meaning     string  `yaml:"meaning" json:"meaning,omitempty"`
+	Source      string  `yaml:"source" json:"source,omitempty"`
+	Destination string  `yaml:"destination" json:"destination,omitempty"`
+	Prefix      string  `yaml:"prefix" json:"prefix,omitempty"`
+	MinLength   int     `yaml:"min_length" json:"min_length,omitempty"`
+	Fields      []Field `yaml:"fields" json:"fields"`
+
+	meaning     *translations.PacketMessageMeaning
+	source      *byte
+	destination *byte
+	prefix      []*byte
+}
+
+// Field extracts a single session value from a packet's data
+type Field struct {
+	Topic  string              `yaml:"topic" json:"topic"`
+	Type   string              `yaml:"type" json:"type"`
+	Byte   int                 `yaml:"byte" json:"byte,omitempty"`
+	Bytes  []int               `yaml:"bytes" json:"bytes,omitempty"`
+	Length int                 `yaml:"length" json:"length,omitempty"`
+	Mask   int                 `yaml:"mask" json:"mask,omitempty"`
+	Endian string              `yaml:"endian" json:"endian,omitempty"`
+	Scale  float64             `yaml:"scale" json:"scale,omitempty"`
+	Offset float64             `yaml:"offset" json:"offset,omitempty"`
+	Values map[int]interface{} `yaml:"values" json:"values,omitempty"`
+	Value  interface{}         `yaml:"value" json:"value,omitempty"`
+	Equals string              `yaml:"equals" json:"equals,omitempty"`
+	Format string              `yaml:"format" json:"format,omitempty"`
+}
+
+// Value is a decoded session value
+type Value struct {
+	Topic string      `json:"topic"`
+	Value interface{} `json:"value"`
+}
+
+// loadTable parses the default translation table, replacing or adding the rules in the given file
+func loadTable(path string) ([]Rule, error) {
+	rules, err := parseTable(defaultTable)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default translation table: %s", err.Error())
+	}
+	if path == "" {
+		return rules, nil
+	}
+
+	// YAML is a superset of JSON, so either can be read here
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	custom, err := parseTable(contents)
+	if err != nil {
+		return nil, fmt.Errorf("invalid translation table %s: %s", path, err.Error())
+	}
+
+	for _, c := range custom {
+		replaced := false
+		for i := range rules {
+			if c.Name != "" && rules[i].Name == c.Name {
+				rules[i] = c
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			rules = append(rules, c)
+		}
+	}
+	return rules, nil
+}
+
+func parseTable(contents []byte) ([]Rule, error) {
+	var rules []Rule
+	if err := yaml.Unmarshal(contents, &rules); err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		if err := rules[i].compile(); err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %s", i, rules[i].Name, err.Error())
+		}
+	}
+	return rules, nil
+}
+
+// compile parses the rule's match conditions, and checks its fields
+func (r *Rule) compile() error {
+	if r.Meaning != "" {
+		m, ok := meanings[r.Meaning]
+		if !ok {
+			return fmt.Errorf("unknown meaning %s", r.Meaning)
+		}
+		r.meaning = &m
+	}
+
+	var err error
+	if r.source, err = parseByte(r.Source); err != nil {
+		return fmt.Errorf("invalid source: %s", err.Error())
+	}
+	if r.destination, err = parseByte(r.Destination); err != nil {
+		return fmt.Errorf("invalid destination: %s", err.Error())
+	}
+
+	prefix := strings.ReplaceAll(r.Prefix, " ", "")
+	if len(prefix)%2 != 0 {
+		return fmt.Errorf("prefix %s must be whole bytes", r.Prefix)
+	}
+	r.prefix = nil
+	for i := 0; i < len(prefix); i += 2 {
+		b, err := parseByte(strings.ReplaceAll(prefix[i:i+2], "..", ""))
+		if err != nil {
+			return fmt.Errorf("invalid prefix: %s", err.Error())
+		}
+		r.prefix = append(r.prefix, b)
+	}
+
+	for _, f := range r.Fields {
+		if f.Topic == "" {
+			return fmt.Errorf("field without a topic")
+		}
+		switch f.Type {
+		case "const", "bool", "enum", "uint", "sum", "hex", "match", "format":
+		default:
+			return fmt.Errorf("field %s has unknown type %s", f.Topic, f.Type)
+		}
+		// Fields are read from the data of packets as they arrive, so anything out of range is caught here
+		if f.Byte < 0 {
+			return fmt.Errorf("field %s has negative byte %d", f.Topic, f.Byte)
+		}
+		for _, b := range f.Bytes {
+			if b < 0 {
+				return fmt.Errorf("field %s has negative byte %d", f.Topic, b)
+			}
+		}
+		if f.Length < 0 || f.Length > maxFieldLength {
+			return fmt.Errorf("field %s has length %d, which must be 1 to %d", f.Topic, f.Length, maxFieldLength)
+		}
+		if f.Mask < 0 || f.Mask > 0xFF {
+			return fmt.Errorf("field %s has mask %X, which must be a single byte", f.Topic, f.Mask)
+		}
+	}
+	return nil
+}
+
+// parseByte reads a single hex byte, where empty matches any byte
+func parseByte(s string) (*byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil || len(b) != 1 {
+		return nil, fmt.Errorf("%s is not a hex byte", s)
+	}
+	return &b[0], nil
+}
+
+// matches determines if the rule applies to a packet. ok is false when the packet had no known meaning
+func (r *Rule) matches(p *gokbus.Packet, m translations.PacketMessageMeaning, ok bool) bool {
+	if r.meaning != nil && (!ok || *r.meaning != m) {
+		return false
+	}
+	if r.source != nil && *r.source != p.Source {
+		return false
+	}
+	if r.destination != nil && *r.destination != p.Destination {
+		return false
+	}
+	if len(p.Data) < r.MinLength || len(p.Data) < len(r.prefix) {
+		return false
+	}
+	for i, b := range r.prefix {
+		if b != nil && *b != p.Data[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// decode a packet with every rule that matches it
+func decode(rules []Rule, p *gokbus.Packet, m translations.PacketMessageMeaning, ok bool) []Value {
+	var values []Value
+	for i := range rules {
+		if !rules[i].matches(p, m, ok) {
+			continue
+		}
+		for _, f := range rules[i].Fields {
+			if v, found := f.extract(p.Data); found {
+				values = append(values, Value{Topic: f.Topic, Value: v})
+			}
+		}
+	}
+	return values
+}
+
+// bits reads the masked bits of a byte, shifted down so the lowest bit of the mask is bit 0
+func (f *Field) bits(b byte) int {
+	if f.Mask == 0 {
+		return int(b)
+	}
+	mask := byte(f.Mask)
+	return int(b&mask) >> bits.TrailingZeros8(mask)
+}
+
+// extract the field's value from packet data, skipping fields that are out of range
+func (f *Field) extract(data []byte) (interface{}, bool) {
+	switch f.Type {
+	case "const":
+		return f.Value, true
+
+	case "hex":
+		return fmt.Sprintf("%02X", data), true
+
+	case "match":
+		return fmt.Sprintf("%02X", data) == strings.ToUpper(f.Equals), true
+
+	case "bool":
+		if f.Byte >= len(data) {
+			return nil, false
+		}
+		return f.bits(data[f.Byte]) != 0, true
+
+	case "enum":
+		if f.Byte >= len(data) {
+			return nil, false
+		}
+		v, ok := f.Values[f.bits(data[f.Byte])]
+		return v, ok
+
+	case "uint":
+		length := f.Length
+		if length == 0 {
+			length = 1
+		}
+		if f.Byte+length > len(data) {
+			return nil, false
+		}
+
+		var n uint64
+		for i := 0; i < length; i++ {
+			b := data[f.Byte+i]
+			if f.Endian == "little" {
+				b = data[f.Byte+length-1-i]
+			}
+			n = n<<8 | uint64(b)
+		}
+		if length == 1 {
+			n = uint64(f.bits(byte(n)))
+		}
+		return f.scale(n), true
+
+	case "sum":
+		sum := 0
+		for _, i := range f.Bytes {
+			if i >= len(data) {
+				return nil, false
+			}
+			sum += int(data[i])
+		}
+		if f.Format != "" {
+			return fmt.Sprintf(f.Format, sum), true
+		}
+		return sum, true
+
+	case "format":
+		args := make([]interface{}, len(f.Bytes))
+		for i, b := range f.Bytes {
+			if b >= len(data) {
+				return nil, false
+			}
+			args[i] = data[b]
+		}
+		return fmt.Sprintf(f.Format, args...), true
+	}
+	return nil, false
+}
+
+// scale a raw integer, keeping it an integer when the scale and offset are whole numbers
+func (f *Field) scale(n uint64) interface{} {
+	scale := f.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	if scale == float64(int(scale)) && f.Offset == float64(int(f.Offset)) {
+		return int(n)*int(scale) + int(f.Offset)
+	}
+	return float64(n)*scale + f.Offset
+}
@@ -0,0 +1,232 @@
+package kbus
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/qcasey/gokbus"
+)
+
+// hexFrame reads a frame written by hand as hex, with its checksum
+func hexFrame(t *testing.T, frame string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(frame, " ", ""))
+	if err != nil {
+		t.Fatalf("invalid frame %s: %s", frame, err.Error())
+	}
+	var sum byte
+	for _, v := range b {
+		sum ^= v
+	}
+	if sum != 0 {
+		t.Fatalf("frame %s has a bad checksum", frame)
+	}
+	return b
+}
+
+// hexPacket reads a packet written by hand as a hex frame: source, length, destination, data and checksum
+func hexPacket(t *testing.T, frame string) gokbus.Packet {
+	t.Helper()
+	b := hexFrame(t, frame)
+	if len(b) < 4 || int(b[1]) != len(b)-2 {
+		t.Fatalf("frame %s has the wrong length", frame)
+	}
+	return gokbus.Packet{Source: b[0], Destination: b[2], Data: b[3 : len(b)-1]}
+}
+
+func TestDefaultTableDecode(t *testing.T) {
+	rules, err := loadTable("")
+	if err != nil {
+		t.Fatalf("failed to load the default table: %s", err.Error())
+	}
+
+	// Frames are synthetic, written from the layouts the old switch decoded.
+	// Each is given the meaning the switch expected of it, rather than asking gokbus
+	tests := []struct {
+		name    string
+		frame   string
+		meaning string
+		want    []Value
+	}{
+		{"ignition off", "80 04 BF 11 00 2A", "IgnitionOff", []Value{{"IGNITION", false}}},
+		{"key in", "44 05 BF 74 04 00 8E", "KeyIn", []Value{{"KEY_DETECTED", true}}},
+		{"key out", "44 05 BF 74 00 FF 75", "KeyOut", []Value{{"KEY_DETECTED", false}}},
+		{"key not detected", "44 05 BF 74 00 04 8E", "KeyNotDetected", []Value{{"KEY_DETECTED", false}}},
+		{"key detected", "44 05 BF 74 05 00 8F", "KeyDetected", []Value{{"KEY_DETECTED", true}}},
+		{"top closed", "9C 04 BF 7C 00 5B", "TopClosed", []Value{{"CONVERTIBLE_TOP_OPEN", false}}},
+		{"top open", "9C 04 BF 7C 01 5A", "TopOpen", []Value{{"CONVERTIBLE_TOP_OPEN", true}}},
+		{"car unlocked", "00 04 BF 72 22 EB", "CarUnlocked", []Value{
+			{"DOORS_LOCKED", false}, {"DOOR_LOCKED_PASSENGER", false}, {"DOOR_LOCKED_DRIVER", false},
+		}},
+		{"car locked", "00 04 BF 72 12 DB", "CarLocked", []Value{
+			{"DOORS_LOCKED", true}, {"DOOR_LOCKED_PASSENGER", true}, {"DOOR_LOCKED_DRIVER", true},
+		}},
+		{"passenger door locked", "00 05 BF 7A 10 00 D0", "PassengerDoorLocked", []Value{{"DOOR_LOCKED_PASSENGER", true}}},
+		{"driver door locked", "00 05 BF 7A 20 00 E0", "DriverDoorLocked", []Value{{"DOOR_LOCKED_DRIVER", true}}},
+		{"aux heating off", "5B 06 80 83 00 00 00 5E", "AuxHeatingOff", []Value{{"CLIMATE.AUX_HEATING", false}}},
+		{"seat memory 1", "72 05 BF 78 01 00 B1", "SeatMemory1", []Value{{"SEAT_MEMORY_1", true}}},
+		{"seat memory 2", "72 05 BF 78 02 00 B2", "SeatMemory2", []Value{{"SEAT_MEMORY_2", true}}},
+		{"seat memory 3", "72 05 BF 78 04 00 B4", "SeatMemory3", []Value{{"SEAT_MEMORY_3", true}}},
+		{"seat memory any", "72 05 BF 78 00 40 F0", "SeatMemoryAny", []Value{{"SEAT_MEMORY_PUSHED", true}}},
+
+		// The odometer estimate and days since service used to panic in binary.LittleEndian.Uint32
+		{"vehicle status", "80 11 BF 54 41 50 12 34 56 0D 02 00 58 02 00 2C 01 00 63", "VehicleStatus", []Value{
+			{"VIN", "4150123456"},
+			{"ODOMETER_ESTIMATE", 52500},
+			{"LITERS_SINCE_LAST_SERVICE", "90"},
+			{"DAYS_SINCE_LAST_SERVICE", 300},
+		}},
+		{"vehicle status short", "80 0A BF 54 41 50 12 34 56 0D 02 0F", "VehicleStatus", nil},
+
+		{"window door status", "00 05 BF 7A 25 51 B4", "WindowDoorMessage", []Value{
+			{"DOORS_LOCKED", true},
+			{"DOOR_OPEN_LEFT_REAR", false},
+			{"DOOR_OPEN_RIGHT_REAR", true},
+			{"DOOR_OPEN_PASSENGER_FRONT", false},
+			{"DOOR_OPEN_DRIVER_FRONT", true},
+			{"WINDOW_OPEN_LEFT_REAR", false},
+			{"WINDOW_OPEN_RIGHT_REAR", false},
+			{"WINDOW_OPEN_PASSENGER_FRONT", false},
+			{"WINDOW_OPEN_DRIVER_FRONT", true},
+			{"SUNROOF_OPEN", true},
+			{"TRUNK_OPEN", false},
+			{"HOOD_OPEN", true},
+			{"INTERIOR_LIGHT_ON", false},
+		}},
+		{"window door status short", "00 04 BF 7A 25 E4", "WindowDoorMessage", nil},
+
+		{"light sensor", "E8 05 D0 59 83 02 E5", "RainLightSensorStatus", []Value{
+			{"LIGHT_SENSOR_REASON", "DARKNESS"},
+			{"LIGHT_SENSOR_ON", true},
+			{"LIGHT_SENSOR_INTENSITY", 131},
+			{"RAIN_LIGHT_SENSOR_STATUS", "598302"},
+		}},
+		{"light sensor unknown reason", "E8 05 D0 59 01 20 45", "RainLightSensorStatus", []Value{
+			{"LIGHT_SENSOR_ON", false},
+			{"LIGHT_SENSOR_INTENSITY", 1},
+			{"RAIN_LIGHT_SENSOR_STATUS", "590120"},
+		}},
+		{"light sensor short", "E8 04 D0 59 83 E6", "RainLightSensorStatus", []Value{{"RAIN_LIGHT_SENSOR_STATUS", "5983"}}},
+
+		{"sensor status", "80 05 BF 13 03 B1 9B", "SensorStatus", []Value{
+			{"HANDBRAKE", true},
+			{"WARNINGS.OIL_PRESSURE", true},
+			{"WARNINGS.BRAKE_PADS", false},
+			{"WARNINGS.TRANSMISSION", false},
+			{"IGNITION", true},
+			{"WARNINGS.DOOR_OPEN", false},
+			{"GEAR", "PARK"},
+		}},
+		{"sensor status short", "80 04 BF 13 03 2B", "SensorStatus", nil},
+
+		{"temperature status", "80 05 BF 19 1A 50 69", "TemperatureStatus", []Value{
+			{"AMBIENT_TEMPERATURE_C", 26},
+			{"COOLANT_TEMPERATURE_C", 80},
+		}},
+		{"air conditioning on", "5B 05 80 83 80 08 D5", "ClimateControl", []Value{
+			{"CLIMATE.AIR_CONDITIONING_ON", true},
+			{"CLIMATE.CONTROL_STATUS", "838008"},
+		}},
+		{"air conditioning off", "5B 05 80 83 00 08 55", "ClimateControl", []Value{
+			{"CLIMATE.AIR_CONDITIONING_ON", false},
+			{"CLIMATE.CONTROL_STATUS", "830008"},
+		}},
+		{"diagnostic", "3F 05 12 A0 88 12 12", "Diagnostic", []Value{{"DIAGNOSTIC", "A08812"}}},
+
+		{"ignition acc 2", "80 04 BF 11 03 29", "IgnitionStatus", []Value{{"KEY_POSITION", 2}}},
+		{"ignition start", "80 04 BF 11 07 2D", "IgnitionStatus", []Value{{"KEY_POSITION", 3}}},
+		{"ignition key out", "80 04 BF 11 00 2A", "IgnitionStatus", []Value{{"KEY_DETECTED", false}}},
+		{"ignition status short", "80 03 BF 11 2D", "IgnitionStatus", nil},
+
+		// Three bytes used to panic in binary.BigEndian.Uint64
+		{"odometer", "80 06 BF 17 12 34 56 5E", "OdometerStatus", []Value{{"ODOMETER", 1193046}}},
+		{"odometer short", "80 05 BF 17 12 34 0B", "OdometerStatus", nil},
+
+		{"speed and rpm", "80 05 BF 18 3C 1E 00", "SpeedRPMStatus", []Value{
+			{"KBUS_SPEED", 120},
+			{"KBUS_RPM", 3000},
+		}},
+		{"range", "80 09 FF 24 06 00 00 00 01 2C 79", "RangeStatus", []Value{{"RANGE_KM", 300}}},
+		{"average speed", "80 09 FF 24 0A 00 00 00 00 55 0D", "RangeStatus", []Value{{"AVG_SPEED", 85}}},
+		{"range short", "80 06 FF 24 06 00 00 5B", "RangeStatus", nil},
+
+		{"ike status", "80 05 BF 1B 00 01 20", "IkeStatus", []Value{{"IKE_STATUS", "1B0001"}}},
+		{"no meaning", "80 05 BF 1B 00 01 20", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := hexPacket(t, tt.frame)
+			m, ok := meanings[tt.meaning]
+			if tt.meaning != "" && !ok {
+				t.Fatalf("unknown meaning %s", tt.meaning)
+			}
+
+			got := decode(rules, &p, m, ok)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decode(% X) = %v, want %v", p.Data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFieldBits(t *testing.T) {
+	rules, err := parseTable([]byte(`
+- name: bits
+  prefix: "40"
+  fields:
+    - {topic: LOW, type: uint, byte: 1, mask: 0x0F}
+    - {topic: HIGH, type: uint, byte: 1, mask: 0xF0}
+    - {topic: MIDDLE, type: uint, byte: 1, mask: 0x3C, scale: 10}
+    - {topic: FLAG, type: bool, byte: 1, mask: 0x80}
+    - topic: MODE
+      type: enum
+      byte: 1
+      mask: 0x30
+      values: {0: OFF, 1: ON, 2: AUTO}
+    - {topic: TOTAL, type: sum, bytes: [1, 2]}
+    - {topic: TOTAL_TEXT, type: sum, bytes: [1, 2], format: "%d"}
+`))
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err.Error())
+	}
+
+	p := gokbus.Packet{Source: 0x80, Destination: 0xBF, Data: []byte{0x40, 0xA7, 0x03}}
+	want := []Value{
+		{"LOW", 7},
+		{"HIGH", 10},
+		{"MIDDLE", 90},
+		{"FLAG", true},
+		{"MODE", "AUTO"},
+		{"TOTAL", 170},
+		{"TOTAL_TEXT", "170"},
+	}
+	if got := decode(rules, &p, 0, false); !reflect.DeepEqual(got, want) {
+		t.Errorf("decode(% X) = %v, want %v", p.Data, got, want)
+	}
+}
+
+func TestParseTableErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown meaning": "- {name: a, meaning: Teleport, fields: []}",
+		"invalid source":  "- {name: a, source: XYZ, fields: []}",
+		"half a byte":     "- {name: a, prefix: \"123\", fields: []}",
+		"no topic":        "- {name: a, fields: [{type: bool}]}",
+		"unknown type":    "- {name: a, fields: [{topic: A, type: float}]}",
+		"negative byte":   "- {name: a, fields: [{topic: A, type: bool, byte: -1}]}",
+		"negative bytes":  "- {name: a, fields: [{topic: A, type: sum, bytes: [1, -2]}]}",
+		"negative length": "- {name: a, fields: [{topic: A, type: uint, length: -1}]}",
+		"long length":     "- {name: a, fields: [{topic: A, type: uint, length: 9}]}",
+		"wide mask":       "- {name: a, fields: [{topic: A, type: bool, mask: 0x100}]}",
+	}
+
+	for name, contents := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseTable([]byte(contents)); err == nil {
+				t.Error("parsed without an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package kbus
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qcasey/gokbus"
+	"github.com/rs/zerolog/log"
+)
+
+// tcpPrefix marks a kbus device reached over the network, i.e. tcp://192.168.1.20:4000 for a ser2net bridge
+const tcpPrefix = "tcp://"
+
+const (
+	tcpDialTimeout = 5 * time.Second
+	tcpMinBackoff  = time.Second
+	tcpMaxBackoff  = 30 * time.Second
+)
+
+// isTCP determines if the kbus device is a network address rather than a serial port
+func isTCP(devicePath string) bool {
+	return strings.HasPrefix(devicePath, tcpPrefix)
+}
+
+// tcpDevice carries raw kbus frames over a TCP connection, reconnecting whenever it drops
+type tcpDevice struct {
+	address string
+	device  *gokbus.KBUS
+	// done is closed once the device is stopped
+	done chan struct{}
+	// connected runs each time the connection is made
+	connected func()
+
+	mutex sync.Mutex
+	conn  net.Conn
+}
+
+// openTCP sets up a kbus device at a TCP address, with the same channels as a serial device
+func openTCP(devicePath string) (*gokbus.KBUS, func(), func()) {
+	t := newTCPDevice(strings.TrimPrefix(devicePath, tcpPrefix), onConnect)
+	return t.device, t.start, t.stop
+}
+
+func newTCPDevice(address string, connected func()) *tcpDevice {
+	return &tcpDevice{
+		address: address,
+		device: &gokbus.KBUS{
+			ReadChannel:  make(chan gokbus.Packet),
+			WriteChannel: make(chan gokbus.Packet, 16),
+			ErrorChannel: make(chan error),
+		},
+		done:      make(chan struct{}),
+		connected: connected,
+	}
+}
+
+func (t *tcpDevice) start() {
+	go t.write()
+
+	backoff := tcpMinBackoff
+	for {
+		conn, err := net.DialTimeout("tcp", t.address, tcpDialTimeout)
+		if err != nil {
+			t.report(fmt.Errorf("failed to connect to %s, retrying in %s: %s", t.address, backoff, err.Error()))
+			select {
+			case <-t.done:
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > tcpMaxBackoff {
+				backoff = tcpMaxBackoff
+			}
+			continue
+		}
+
+		if !t.setConn(conn) {
+			conn.Close()
+			return
+		}
+		log.Info().Msgf("Connected to kbus at %s", t.address)
+		backoff = tcpMinBackoff
+
+		err = t.read(conn)
+		t.setConn(nil)
+		conn.Close()
+		if t.stopped() {
+			return
+		}
+		t.report(fmt.Errorf("lost connection to %s: %s", t.address, err.Error()))
+	}
+}
+
+// stop closes the connection and ends the device's goroutines
+func (t *tcpDevice) stop() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.stopped() {
+		return
+	}
+	close(t.done)
+	if t.conn != nil {
+		t.conn.Close()
+	}
+}
+
+func (t *tcpDevice) stopped() bool {
+	select {
+	case <-t.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// report an error to the device, unless it's been stopped and nothing reads them anymore
+func (t *tcpDevice) report(err error) {
+	select {
+	case t.device.ErrorChannel <- err:
+	case <-t.done:
+	}
+}
+
+// read frames from the connection until it fails or the device is stopped
+func (t *tcpDevice) read(conn net.Conn) error {
+	var parser frameParser
+	buffer := make([]byte, 256)
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return err
+		}
+		for _, p := range parser.feed(buffer[:n]) {
+			select {
+			case t.device.ReadChannel <- p:
+			case <-t.done:
+				return nil
+			}
+		}
+	}
+}
+
+// write every packet from the write channel to the current connection
+func (t *tcpDevice) write() {
+	for {
+		var p gokbus.Packet
+		select {
+		case <-t.done:
+			return
+		case p = <-t.device.WriteChannel:
+		}
+
+		t.mutex.Lock()
+		conn := t.conn
+		t.mutex.Unlock()
+
+		if conn == nil {
+			t.report(fmt.Errorf("dropped kbus packet % X, not connected to %s", p.Data, t.address))
+			continue
+		}
+		if _, err := conn.Write(encodeFrame(p)); err != nil {
+			t.report(fmt.Errorf("failed to write to %s: %s", t.address, err.Error()))
+		}
+	}
+}
+
+// setConn tracks the current connection, bringing the session up to date on each new one.
+// Returns false when the device was stopped while connecting
+func (t *tcpDevice) setConn(conn net.Conn) bool {
+	t.mutex.Lock()
+	if conn != nil && t.stopped() {
+		t.mutex.Unlock()
+		return false
+	}
+	t.conn = conn
+	t.mutex.Unlock()
+
+	setConnected(conn != nil)
+	if conn != nil {
+		go t.connected()
+	}
+	return true
+}
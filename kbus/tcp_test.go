@@ -0,0 +1,146 @@
+package kbus
+
+import (
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/qcasey/gokbus"
+	"github.com/qcasey/mdroid/pkg/core"
+)
+
+func TestFrameParser(t *testing.T) {
+	sensor := gokbus.Packet{Source: 0x80, Destination: 0xBF, Data: []byte{0x13, 0x03, 0xB1}}
+	poll := gokbus.Packet{Source: 0x68, Destination: 0x18, Data: []byte{0x01}}
+
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []gokbus.Packet
+	}{
+		{"frame", []string{"80 05 BF 13 03 B1 9B"}, []gokbus.Packet{sensor}},
+		{"split frame", []string{"80 05 BF", "13 03", "B1 9B"}, []gokbus.Packet{sensor}},
+		{"two frames", []string{"80 05 BF 13 03 B1 9B 68 03 18 01 72"}, []gokbus.Packet{sensor, poll}},
+		{"noise", []string{"FF 00 80 05 BF 13 03 B1 9B"}, []gokbus.Packet{sensor}},
+		{"too long", []string{"80 41", "68 03 18 01 72"}, []gokbus.Packet{poll}},
+		{"bad checksum", []string{"68 03 18 01 73 68 02 18 01 72"}, nil},
+		{"partial", []string{"80 05 BF 13 03 B1"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var parser frameParser
+			var got []gokbus.Packet
+			for _, chunk := range tt.chunks {
+				b, err := parseHex(chunk)
+				if err != nil {
+					t.Fatalf("invalid chunk %s: %s", chunk, err.Error())
+				}
+				got = append(got, parser.feed(b)...)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("feed(%v) = %v, want %v", tt.chunks, got, tt.want)
+			}
+		})
+	}
+}
+
+// receivePacket waits on the device for a packet read from the connection
+func receivePacket(t *testing.T, device *gokbus.KBUS) gokbus.Packet {
+	t.Helper()
+	select {
+	case p := <-device.ReadChannel:
+		return p
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a kbus packet")
+	}
+	return gokbus.Packet{}
+}
+
+func TestTCPReconnect(t *testing.T) {
+	core.Session = core.NewDatastore(false)
+
+	// A stand-in for a ser2net bridge
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err.Error())
+	}
+	defer listener.Close()
+
+	connects := make(chan struct{}, 2)
+	tcp := newTCPDevice(listener.Addr().String(), func() { connects <- struct{}{} })
+	device := tcp.device
+	go func() {
+		for range device.ErrorChannel {
+		}
+	}()
+	setDevice(device)
+	defer setDevice(nil)
+	exited := make(chan struct{})
+	go func() {
+		tcp.start()
+		close(exited)
+	}()
+	defer tcp.stop()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept: %s", err.Error())
+	}
+	conn.Write([]byte{0x80, 0x05, 0xBF})
+	conn.Write([]byte{0x13, 0x03, 0xB1, 0x9B})
+	want := gokbus.Packet{Source: 0x80, Destination: 0xBF, Data: []byte{0x13, 0x03, 0xB1}}
+	if p := receivePacket(t, device); !reflect.DeepEqual(p, want) {
+		t.Errorf("read %v, want %v", p, want)
+	}
+
+	written := gokbus.Packet{Source: 0x68, Destination: 0x18, Data: []byte{0x01}}
+	device.WriteChannel <- written
+	frame := make([]byte, len(encodeFrame(written)))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(conn, frame); err != nil {
+		t.Fatalf("failed to read the written frame: %s", err.Error())
+	}
+	if !reflect.DeepEqual(frame, encodeFrame(written)) {
+		t.Errorf("wrote % X, want % X", frame, encodeFrame(written))
+	}
+
+	// Dropping the connection should have the device dial back in
+	conn.Close()
+	conn, err = listener.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept the reconnect: %s", err.Error())
+	}
+	defer conn.Close()
+	conn.Write([]byte{0x68, 0x03, 0x18, 0x01, 0x72})
+	if p := receivePacket(t, device); !reflect.DeepEqual(p, written) {
+		t.Errorf("read %v after reconnecting, want %v", p, written)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-connects:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("connected %d times, want 2", i)
+		}
+	}
+	// kbus.connected is published before the device calls back on connecting
+	if !core.Session.Store.GetBool("kbus.connected") {
+		t.Error("kbus.connected is false after reconnecting")
+	}
+
+	// Once stopped, the connection is closed and writes are refused rather than dropped
+	tcp.stop()
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read %v from a stopped device, want EOF", err)
+	}
+	select {
+	case <-exited:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the device kept running after it was stopped")
+	}
+	if err := WritePackets([]gokbus.Packet{written}); err != ErrDisconnected {
+		t.Errorf("WritePackets() = %v while disconnected, want ErrDisconnected", err)
+	}
+}
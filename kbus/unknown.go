@@ -0,0 +1,143 @@
+package kbus
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/qcasey/gokbus"
+	"github.com/qcasey/mdroid/pkg/core"
+)
+
+// maxUnknown limits how many groups of unknown packets are kept
+const maxUnknown = 500
+
+// Unknown is a group of packets without a known meaning, sharing a source, destination and first data byte
+type Unknown struct {
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Prefix      string    `json:"prefix"`
+	Count       int       `json:"count"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+	// Varying lists the data bytes that have differed between packets
+	Varying []int  `json:"varying"`
+	Last    string `json:"last"`
+
+	first   []byte
+	varying map[int]bool
+}
+
+var (
+	unknown     = make(map[string]*Unknown)
+	unknownLock sync.Mutex
+)
+
+// catalogue a packet that translations couldn't make sense of
+func catalogue(p *gokbus.Packet) {
+	if len(p.Data) == 0 {
+		return
+	}
+	key := fmt.Sprintf("%02X%02X%02X", p.Source, p.Destination, p.Data[0])
+	now := time.Now()
+
+	unknownLock.Lock()
+	defer unknownLock.Unlock()
+
+	u, ok := unknown[key]
+	if !ok {
+		if len(unknown) >= maxUnknown {
+			return
+		}
+		u = &Unknown{
+			Source:      fmt.Sprintf("%02X", p.Source),
+			Destination: fmt.Sprintf("%02X", p.Destination),
+			Prefix:      fmt.Sprintf("%02X", p.Data[0]),
+			FirstSeen:   now,
+			first:       append([]byte{}, p.Data...),
+			varying:     make(map[int]bool),
+		}
+		unknown[key] = u
+	}
+
+	u.Count++
+	u.LastSeen = now
+	u.Last = fmt.Sprintf("% X", p.Data)
+
+	// Bytes past the end of the shorter packet vary too
+	for i := 0; i < len(p.Data) || i < len(u.first); i++ {
+		if i >= len(p.Data) || i >= len(u.first) || p.Data[i] != u.first[i] {
+			u.varying[i] = true
+		}
+	}
+}
+
+// Catalogue lists every group of unknown packets, most common first
+func Catalogue() []Unknown {
+	unknownLock.Lock()
+	defer unknownLock.Unlock()
+
+	catalogue := make([]Unknown, 0, len(unknown))
+	for _, u := range unknown {
+		c := *u
+		c.Varying = make([]int, 0, len(u.varying))
+		for i := range u.varying {
+			c.Varying = append(c.Varying, i)
+		}
+		sort.Ints(c.Varying)
+		catalogue = append(catalogue, c)
+	}
+
+	sort.Slice(catalogue, func(i, j int) bool {
+		if catalogue[i].Count != catalogue[j].Count {
+			return catalogue[i].Count > catalogue[j].Count
+		}
+		return catalogue[i].FirstSeen.Before(catalogue[j].FirstSeen)
+	})
+	return catalogue
+}
+
+// toRule drafts a translation rule for a group of unknown packets, publishing each byte that varies
+func (u Unknown) toRule() Rule {
+	rule := Rule{
+		Name:        fmt.Sprintf("unknown_%s_%s_%s", u.Source, u.Destination, u.Prefix),
+		Source:      u.Source,
+		Destination: u.Destination,
+		Prefix:      u.Prefix,
+	}
+
+	topic := fmt.Sprintf("KBUS.%s_%s_%s", u.Source, u.Destination, u.Prefix)
+	for _, i := range u.Varying {
+		rule.Fields = append(rule.Fields, Field{Topic: fmt.Sprintf("%s.BYTE_%d", topic, i), Type: "uint", Byte: i})
+	}
+	if len(rule.Fields) == 0 {
+		rule.Fields = []Field{{Topic: topic, Type: "hex"}}
+	}
+	return rule
+}
+
+// HandleUnknown lists the catalogue of packets without a known meaning
+func HandleUnknown(w http.ResponseWriter, r *http.Request) {
+	core.WriteNewResponse(&w, r, core.JSONResponse{Output: Catalogue(), OK: true})
+}
+
+// HandleUnknownExport drafts translation rules for every unknown packet,
+// ready to be edited into kbus.translation_table
+func HandleUnknownExport(w http.ResponseWriter, r *http.Request) {
+	catalogue := Catalogue()
+	rules := make([]Rule, 0, len(catalogue))
+	for _, u := range catalogue {
+		rules = append(rules, u.toRule())
+	}
+	core.WriteNewResponse(&w, r, core.JSONResponse{Output: rules, OK: true})
+}
+
+// HandleUnknownReset clears the catalogue of unknown packets
+func HandleUnknownReset(w http.ResponseWriter, r *http.Request) {
+	unknownLock.Lock()
+	unknown = make(map[string]*Unknown)
+	unknownLock.Unlock()
+	core.WriteNewResponse(&w, r, core.JSONResponse{Output: "OK", OK: true})
+}
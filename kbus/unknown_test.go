@@ -0,0 +1,134 @@
+package kbus
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/qcasey/gokbus"
+)
+
+func TestCatalogue(t *testing.T) {
+	tests := []struct {
+		name    string
+		packets []gokbus.Packet
+		want    []Unknown
+	}{
+		{"nothing varies", []gokbus.Packet{
+			{Source: 0x3F, Destination: 0x00, Data: []byte{0x0C, 0x01}},
+			{Source: 0x3F, Destination: 0x00, Data: []byte{0x0C, 0x01}},
+		}, []Unknown{
+			{Source: "3F", Destination: "00", Prefix: "0C", Count: 2, Varying: []int{}, Last: "0C 01"},
+		}},
+		{"varying bytes", []gokbus.Packet{
+			{Source: 0x3F, Destination: 0x00, Data: []byte{0x0C, 0x01, 0x10, 0x00}},
+			{Source: 0x3F, Destination: 0x00, Data: []byte{0x0C, 0x02, 0x10, 0x00}},
+			{Source: 0x3F, Destination: 0x00, Data: []byte{0x0C, 0x02, 0x10, 0x05}},
+		}, []Unknown{
+			{Source: "3F", Destination: "00", Prefix: "0C", Count: 3, Varying: []int{1, 3}, Last: "0C 02 10 05"},
+		}},
+		{"different lengths", []gokbus.Packet{
+			{Source: 0x3F, Destination: 0x00, Data: []byte{0x0C, 0x01}},
+			{Source: 0x3F, Destination: 0x00, Data: []byte{0x0C, 0x01, 0x10, 0x00}},
+			{Source: 0x3F, Destination: 0x00, Data: []byte{0x0C}},
+		}, []Unknown{
+			{Source: "3F", Destination: "00", Prefix: "0C", Count: 3, Varying: []int{1, 2, 3}, Last: "0C"},
+		}},
+		{"grouped by source, destination and first byte", []gokbus.Packet{
+			{Source: 0x3F, Destination: 0x00, Data: []byte{0x0C, 0x01}},
+			{Source: 0x3F, Destination: 0x00, Data: []byte{0x0D, 0x01}},
+			{Source: 0x3F, Destination: 0xBF, Data: []byte{0x0C, 0x01}},
+			{Source: 0x3F, Destination: 0x00, Data: []byte{0x0D, 0x01}},
+			{Source: 0x80, Destination: 0x00, Data: []byte{0x0C, 0x01}},
+			{Source: 0x80, Destination: 0x00, Data: []byte{}},
+		}, []Unknown{
+			{Source: "3F", Destination: "00", Prefix: "0D", Count: 2, Varying: []int{}, Last: "0D 01"},
+			{Source: "3F", Destination: "00", Prefix: "0C", Count: 1, Varying: []int{}, Last: "0C 01"},
+			{Source: "3F", Destination: "BF", Prefix: "0C", Count: 1, Varying: []int{}, Last: "0C 01"},
+			{Source: "80", Destination: "00", Prefix: "0C", Count: 1, Varying: []int{}, Last: "0C 01"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unknown = make(map[string]*Unknown)
+			for i := range tt.packets {
+				catalogue(&tt.packets[i])
+			}
+
+			got := Catalogue()
+			for i := range got {
+				// Only the order of when groups were seen matters here
+				if got[i].FirstSeen.IsZero() || got[i].LastSeen.Before(got[i].FirstSeen) {
+					t.Errorf("group %d was seen from %s to %s", i, got[i].FirstSeen, got[i].LastSeen)
+				}
+				got[i].FirstSeen, got[i].LastSeen = tt.want[i].FirstSeen, tt.want[i].LastSeen
+				got[i].first, got[i].varying = nil, nil
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Catalogue() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCatalogueLimit(t *testing.T) {
+	unknown = make(map[string]*Unknown)
+	for i := 0; i < maxUnknown+10; i++ {
+		catalogue(&gokbus.Packet{Source: byte(i >> 8), Destination: byte(i), Data: []byte{0x01}})
+	}
+	if len(unknown) != maxUnknown {
+		t.Fatalf("catalogued %d groups, want at most %d", len(unknown), maxUnknown)
+	}
+
+	// Groups already in the catalogue keep counting
+	catalogue(&gokbus.Packet{Source: 0x00, Destination: 0x00, Data: []byte{0x01}})
+	if u := unknown["000001"]; u == nil || u.Count != 2 {
+		t.Errorf("a known group wasn't counted once the catalogue was full: %+v", u)
+	}
+}
+
+func TestExportedRules(t *testing.T) {
+	unknown = make(map[string]*Unknown)
+	packets := []gokbus.Packet{
+		{Source: 0x3F, Destination: 0x00, Data: []byte{0x0C, 0x01, 0x10}},
+		{Source: 0x3F, Destination: 0x00, Data: []byte{0x0C, 0x02, 0x10, 0x07}},
+		{Source: 0x80, Destination: 0xBF, Data: []byte{0x2A, 0x00}},
+	}
+	for i := range packets {
+		catalogue(&packets[i])
+	}
+
+	// Exported rules are meant to be pasted into kbus.translation_table, so they have to parse as one
+	w := httptest.NewRecorder()
+	HandleUnknownExport(w, httptest.NewRequest("GET", "/kbus/unknown/export", nil))
+	rules, err := parseTable(w.Body.Bytes())
+	if err != nil {
+		t.Fatalf("exported rules don't parse as a translation table: %s\n%s", err.Error(), w.Body.String())
+	}
+	if len(rules) != 2 {
+		t.Fatalf("exported %d rules, want 2", len(rules))
+	}
+
+	tests := []struct {
+		p    gokbus.Packet
+		want []Value
+	}{
+		{gokbus.Packet{Source: 0x3F, Destination: 0x00, Data: []byte{0x0C, 0x05, 0x10, 0x09}}, []Value{
+			{"KBUS.3F_00_0C.BYTE_1", 5},
+			{"KBUS.3F_00_0C.BYTE_3", 9},
+		}},
+		{gokbus.Packet{Source: 0x3F, Destination: 0x00, Data: []byte{0x0C, 0x05}}, []Value{
+			{"KBUS.3F_00_0C.BYTE_1", 5},
+		}},
+		{gokbus.Packet{Source: 0x80, Destination: 0xBF, Data: []byte{0x2A, 0x00}}, []Value{
+			{"KBUS.80_BF_2A", "2A00"},
+		}},
+		{gokbus.Packet{Source: 0x80, Destination: 0xBF, Data: []byte{0x2B, 0x00}}, nil},
+	}
+	for _, tt := range tests {
+		if got := decode(rules, &tt.p, 0, false); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("decode(% X) = %v, want %v", tt.p.Data, got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package kbus
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
@@ -10,37 +11,84 @@ import (
 	"github.com/qcasey/gokbus/pkg/prepackets"
 	"github.com/qcasey/mdroid/mserial"
 	"github.com/qcasey/mdroid/pkg/core"
-	"github.com/rs/zerolog/log"
 )
 
 // WritePackets adds a raw packet to the KBUS write channel
 func WritePackets(packets []gokbus.Packet) error {
-	if kbusDevice == nil {
-		return fmt.Errorf("kbus device is nil")
+	return WritePacketsContext(context.Background(), packets)
+}
+
+// WritePacketsContext adds raw packets to the KBUS write channel, logging them with the context's correlation ID
+func WritePacketsContext(ctx context.Context, packets []gokbus.Packet) error {
+	if currentDevice() == nil {
+		return ErrDisconnected
+	}
+	for _, p := range packets {
+		core.Log(ctx).Debug().Msgf("Writing kbus packet %02X -> %02X: % X", p.Source, p.Destination, p.Data)
+	}
+	writes.push(ctx, priorityUser, packets)
+	return nil
+}
+
+// writePacketsAndWait queues packets like WritePacketsContext, then waits until every one is written.
+// Stops waiting once the context is done, though packets already queued are still written
+func writePacketsAndWait(ctx context.Context, packets []gokbus.Packet) error {
+	if len(packets) == 0 {
+		return nil
+	}
+	if currentDevice() == nil {
+		return ErrDisconnected
 	}
 	for _, p := range packets {
-		kbusDevice.WriteChannel <- p
+		core.Log(ctx).Debug().Msgf("Writing kbus packet %02X -> %02X: % X", p.Source, p.Destination, p.Data)
+	}
+
+	written := writes.pushTracked(ctx, priorityUser, packets)
+	for range packets {
+		select {
+		case err := <-written:
+			if err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// pollCommand queues a prepared command behind any user commands, unless it's already waiting to be written
+func pollCommand(command string) error {
+	if currentDevice() == nil {
+		return ErrDisconnected
 	}
+	packets := prepackets.RequestToPacket(command)
+	if packets == nil {
+		return fmt.Errorf("Command '%s' not found in prepared list of packets", command)
+	}
+	writes.push(context.Background(), priorityPoll, packets)
 	return nil
 }
 
 // WriteCommand adds a directive to the KBUS write channel
 func WriteCommand(command string) error {
-	if kbusDevice == nil {
-		return fmt.Errorf("kbus device is nil")
+	return WriteCommandContext(context.Background(), command)
+}
+
+// WriteCommandContext adds a directive to the KBUS write channel, logging it with the context's correlation ID
+func WriteCommandContext(ctx context.Context, command string) error {
+	if currentDevice() == nil {
+		return ErrDisconnected
 	}
+	core.Log(ctx).Info().Msgf("Writing kbus command %s", command)
 
-	//
-	// Special cases, where one packet doesn't do the full job
-	//
-	switch command {
-	case "RollWindowsUp":
-		go WritePackets(prepackets.PopWindowsUp)
-		go WritePackets(prepackets.PopWindowsUp)
-		return nil
-	case "RollWindowsDown":
-		go WritePackets(prepackets.PopWindowsDown)
-		go WritePackets(prepackets.PopWindowsDown)
+	// Macros, where one packet doesn't do the full job
+	if IsMacro(command) {
+		go func() {
+			if err := RunMacro(ctx, command); err != nil {
+				core.Log(ctx).Error().Err(err).Msgf("Failed to run kbus macro %s", command)
+			}
+		}()
 		return nil
 	}
 
@@ -49,36 +97,67 @@ func WriteCommand(command string) error {
 		return fmt.Errorf("Command '%s' not found in prepared list of packets", command)
 	}
 
-	return WritePackets(packets)
+	return WritePacketsContext(ctx, packets)
 }
 
-// WriteData with given src, dest, and data to the kbus
+// WriteData with given hex src, dest, and data to the kbus
 func WriteData(src string, dest string, data string) error {
-	if len(src) != 2 {
-		return fmt.Errorf("%s incorrect length, must represent byte", src)
-	}
-	if len(dest) != 2 {
-		return fmt.Errorf("%s incorrect length, must represent byte", dest)
+	_, err := WriteRaw(context.Background(), src, dest, data, "")
+	return err
+}
+
+// WriteRaw queues a packet given as hex, i.e. "68", "18", "0A 01". If a checksum is given,
+// it must match the one computed for the frame. Returns the exact frame queued
+func WriteRaw(ctx context.Context, src string, dest string, data string, checksum string) ([]byte, error) {
+	p, frame, err := parseRaw(src, dest, data, checksum)
+	if err != nil {
+		return nil, err
 	}
-	if kbusDevice == nil {
-		return fmt.Errorf("kbus device is nil")
+	if err := WritePacketsContext(ctx, []gokbus.Packet{p}); err != nil {
+		return nil, err
 	}
+	return frame, nil
+}
 
-	newPacket := gokbus.Packet{
-		Source:      []byte(src)[0],
-		Destination: []byte(dest)[0],
-		Data:        []byte(data),
+// parseRaw validates a packet given as hex, returning it along with its full frame
+func parseRaw(src string, dest string, data string, checksum string) (gokbus.Packet, []byte, error) {
+	source, err := parseHex(src)
+	if err != nil || len(source) != 1 {
+		return gokbus.Packet{}, nil, fmt.Errorf("source %s must be a single hex byte", src)
+	}
+	destination, err := parseHex(dest)
+	if err != nil || len(destination) != 1 {
+		return gokbus.Packet{}, nil, fmt.Errorf("destination %s must be a single hex byte", dest)
+	}
+	payload, err := parseHex(data)
+	if err != nil {
+		return gokbus.Packet{}, nil, fmt.Errorf("data %s is not valid hex: %s", data, err.Error())
+	}
+	if len(payload) == 0 || len(payload) > maxDataLength {
+		return gokbus.Packet{}, nil, fmt.Errorf("data must be between 1 and %d bytes, got %d", maxDataLength, len(payload))
 	}
 
-	kbusDevice.WriteChannel <- newPacket
+	p := gokbus.Packet{Source: source[0], Destination: destination[0], Data: payload}
+	frame := encodeFrame(p)
 
-	return nil
+	if checksum != "" {
+		given, err := parseHex(checksum)
+		if err != nil || len(given) != 1 {
+			return gokbus.Packet{}, nil, fmt.Errorf("checksum %s must be a single hex byte", checksum)
+		}
+		if given[0] != frame[len(frame)-1] {
+			return gokbus.Packet{}, nil, fmt.Errorf("checksum %02X does not match computed checksum %02X", given[0], frame[len(frame)-1])
+		}
+	}
+	return p, frame, nil
 }
 
 // ParseCommand is a list of pre-approved routes to KBUS for easier routing
 func parseCommand() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		params := mux.Vars(r)
+		ctx := r.Context()
+		logger := core.Log(ctx)
 
 		if len(params["device"]) == 0 || len(params["command"]) == 0 {
 			core.WriteNewResponse(&w, r, core.JSONResponse{Output: "Error: One or more required params is empty", OK: false})
@@ -99,18 +178,18 @@ func parseCommand() http.HandlerFunc {
 		if cannotBeParsedIntoBoolean {
 			switch device {
 			case "door", "top", "convertible_top", "hazard", "flasher", "interior":
-				log.Error().Err(err).Msgf("The given command %s could not be parsed into a bool", device)
+				logger.Error().Err(err).Msgf("The given command %s could not be parsed into a bool", device)
 				return
 			}
 		}
 
-		log.Info().Msgf("Attempting to send command %s to device %s", command, device)
+		logger.Info().Msgf("Attempting to send command %s to device %s", command, device)
 
 		// If the car's ACC power isn't on, it won't be ready for requests. Wake it up first
 		if !core.Session.Store.GetBool("acc_power") {
-			err = WritePackets([]gokbus.Packet{prepackets.RequestIgnitionStatus}) // this will be swallowed
+			err = WritePacketsContext(ctx, []gokbus.Packet{prepackets.RequestIgnitionStatus}) // this will be swallowed
 			if err != nil {
-				log.Error().Err(err).Msgf("Failed to parse command")
+				logger.Error().Err(err).Msgf("Failed to parse command")
 				return
 			}
 		}
@@ -122,86 +201,86 @@ func parseCommand() http.HandlerFunc {
 			doorsAreLocked := core.Session.Store.GetBool("doors_locked")
 			if mserial.Enabled &&
 				((isPositive && !doorsAreLocked) || (!isPositive && doorsAreLocked)) {
-				mserial.Await("toggleDoorLocks")
+				mserial.AwaitContext(ctx, "toggleDoorLocks")
 			} else {
-				log.Info().Msgf("Request to %s doors denied, door status is %t", command, doorsAreLocked)
+				logger.Info().Msgf("Request to %s doors denied, door status is %t", command, doorsAreLocked)
 			}
 		case "window":
 			if command == "popdown" {
-				err = WritePackets(prepackets.PopWindowsDown)
+				err = WritePacketsContext(ctx, prepackets.PopWindowsDown)
 			} else if command == "popup" {
-				err = WritePackets(prepackets.PopWindowsUp)
+				err = WritePacketsContext(ctx, prepackets.PopWindowsUp)
 			} else if isPositive {
-				err = WriteCommand("RollWindowsUp")
+				err = WriteCommandContext(ctx, "RollWindowsUp")
 			} else {
-				err = WriteCommand("RollWindowsDown")
+				err = WriteCommandContext(ctx, "RollWindowsDown")
 			}
 		case "trunk":
-			err = WritePackets([]gokbus.Packet{prepackets.OpenTrunk})
+			err = WritePacketsContext(ctx, []gokbus.Packet{prepackets.OpenTrunk})
 		case "hazard":
 			if isPositive {
-				err = WritePackets([]gokbus.Packet{prepackets.FlashHazards})
+				err = WritePacketsContext(ctx, []gokbus.Packet{prepackets.FlashHazards})
 			} else {
-				err = WritePackets([]gokbus.Packet{prepackets.TurnOffAllExteriorLights})
+				err = WritePacketsContext(ctx, []gokbus.Packet{prepackets.TurnOffAllExteriorLights})
 			}
 		case "flasher":
 			if isPositive {
-				err = WritePackets([]gokbus.Packet{prepackets.FlashLowBeamsAndHazards})
+				err = WritePacketsContext(ctx, []gokbus.Packet{prepackets.FlashLowBeamsAndHazards})
 			} else {
-				err = WritePackets([]gokbus.Packet{prepackets.TurnOffAllExteriorLights})
+				err = WritePacketsContext(ctx, []gokbus.Packet{prepackets.TurnOffAllExteriorLights})
 			}
 		case "interior":
 			if isPositive {
-				err = WritePackets([]gokbus.Packet{prepackets.ToggleInteriorLights})
+				err = WritePacketsContext(ctx, []gokbus.Packet{prepackets.ToggleInteriorLights})
 			} else {
-				err = WritePackets([]gokbus.Packet{prepackets.ToggleInteriorLights})
+				err = WritePacketsContext(ctx, []gokbus.Packet{prepackets.ToggleInteriorLights})
 			}
 		case "clown", "nose":
-			err = WritePackets([]gokbus.Packet{prepackets.TurnOnClownNose})
+			err = WritePacketsContext(ctx, []gokbus.Packet{prepackets.TurnOnClownNose})
 		case "mode":
-			err = WritePackets(prepackets.PressMode)
+			err = WritePacketsContext(ctx, prepackets.PressMode)
 		case "radio", "nav", "stereo":
 			switch command {
 			case "am":
-				err = WritePackets(prepackets.PressAM)
+				err = WritePacketsContext(ctx, prepackets.PressAM)
 			case "fm":
-				err = WritePackets(prepackets.PressFM)
+				err = WritePacketsContext(ctx, prepackets.PressFM)
 			case "next":
-				err = WritePackets(prepackets.PressNext)
+				err = WritePacketsContext(ctx, prepackets.PressNext)
 			case "prev":
-				err = WritePackets(prepackets.PressPrev)
+				err = WritePacketsContext(ctx, prepackets.PressPrev)
 			case "mode":
-				err = WritePackets(prepackets.PressMode)
+				err = WritePacketsContext(ctx, prepackets.PressMode)
 			case "1":
-				err = WritePackets(prepackets.PressNum1)
+				err = WritePacketsContext(ctx, prepackets.PressNum1)
 			case "2":
-				err = WritePackets(prepackets.PressNum2)
+				err = WritePacketsContext(ctx, prepackets.PressNum2)
 			case "3":
-				err = WritePackets(prepackets.PressNum3)
+				err = WritePacketsContext(ctx, prepackets.PressNum3)
 			case "4":
-				err = WritePackets(prepackets.PressNum4)
+				err = WritePacketsContext(ctx, prepackets.PressNum4)
 			case "5":
-				err = WritePackets(prepackets.PressNum5)
+				err = WritePacketsContext(ctx, prepackets.PressNum5)
 			case "6":
-				err = WritePackets(prepackets.PressNum6)
+				err = WritePacketsContext(ctx, prepackets.PressNum6)
 			default:
-				err = WritePackets(prepackets.PressStereoPower)
+				err = WritePacketsContext(ctx, prepackets.PressStereoPower)
 			}
 		default:
-			log.Error().Msgf("Invalid device %s", device)
+			logger.Error().Msgf("Invalid device %s", device)
 			response := core.JSONResponse{Output: fmt.Sprintf("Invalid device %s", device), OK: false}
 			response.Write(&w, r)
 			return
 		}
 
 		if err != nil {
-			log.Error().Err(err).Msgf("Error parsing command %s", command)
+			logger.Error().Err(err).Msgf("Error parsing command %s", command)
 			core.WriteNewResponse(&w, r, core.JSONResponse{Output: err.Error(), OK: false})
 			return
 		}
 
 		// Yay
-		log.Info().Msgf("Successfully wrote %s to %s.", command, device)
+		logger.Info().Msgf("Successfully wrote %s to %s.", command, device)
 		core.WriteNewResponse(&w, r, core.JSONResponse{Output: device, OK: true})
 	}
 }
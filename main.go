@@ -1,19 +1,29 @@
 package main
 
 import (
+	"os"
+
 	"github.com/qcasey/mdroid/artwork"
 	"github.com/qcasey/mdroid/bluetooth"
 	"github.com/qcasey/mdroid/can"
+	"github.com/qcasey/mdroid/diagnostics"
 	"github.com/qcasey/mdroid/enginesound"
 	"github.com/qcasey/mdroid/kbus"
 	"github.com/qcasey/mdroid/mqtt"
 	"github.com/qcasey/mdroid/mserial"
+	"github.com/qcasey/mdroid/pkg/config"
 	"github.com/qcasey/mdroid/pkg/core"
+	"github.com/qcasey/mdroid/pkg/core/component"
 	"github.com/qcasey/mdroid/pkg/server"
 	"github.com/qcasey/mdroid/prometheus"
 )
 
 func main() {
+	// Config tooling runs without starting the server
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(config.Run(os.Args[2:]))
+	}
+
 	// srv.Router is open to route modifications, middleware, etc
 	srv := server.New()
 
@@ -21,9 +31,12 @@ func main() {
 	go customHooks()
 
 	// Start modules
+	component.Start(srv)
 	prometheus.Start(srv)
 	mserial.Start(srv)
 	bluetooth.Start(srv)
+	// Before kbus, whose /{device}/{command} route would shadow /diagnostics/dtc
+	diagnostics.Start(srv)
 	kbus.Start(srv)
 	can.Start(srv)
 	mqtt.Start(srv)
@@ -2,8 +2,10 @@ package mqtt
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"reflect"
 	"strings"
@@ -79,7 +81,8 @@ func Start(srv *server.Server) {
 		for {
 			select {
 			case message := <-mqttSettingsHook:
-				handleStateUpdate(fmt.Sprintf("settings/%s", message.Topic), message.Value)
+				// Never mirror credentials to the broker
+				handleStateUpdate(fmt.Sprintf("settings/%s", message.Topic), core.Redact(message.Topic, message.Value))
 			case message := <-mqttSessionHook:
 				handleStateUpdate(fmt.Sprintf("session/%s", message.Topic), message.Value)
 			}
@@ -117,6 +120,11 @@ func handleStateUpdate(topic string, value interface{}) {
 			handleStateUpdate(fmt.Sprintf("%s/%s", topic, newKey.(string)), newValue)
 		}
 		return
+	case map[string]interface{}:
+		for newKey, newValue := range vv {
+			handleStateUpdate(fmt.Sprintf("%s/%s", topic, newKey), newValue)
+		}
+		return
 	default:
 		log.Warn().Msgf("Received invalid interface (%s) for key %s. Got: '%v'", reflect.TypeOf(value).String(), topic, vv)
 		return
@@ -136,44 +144,47 @@ func handleStateUpdate(topic string, value interface{}) {
 }
 
 var f mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
-	log.Info().Msgf("MQTT Message: %s => %s", msg.Topic(), msg.Payload())
+	// Requests start here, tag them so they can be traced through to the hardware
+	ctx := core.WithRequestID(context.Background(), core.NewRequestID())
+	logger := core.Log(ctx)
+	logger.Info().Msgf("MQTT Message: %s => %s", msg.Topic(), msg.Payload())
 
 	request := remoteMessage{}
-	err := json.Unmarshal(msg.Payload(), &request)
+	if err := json.Unmarshal(msg.Payload(), &request); err != nil {
+		logger.Error().Err(err).Msg("Could not decode request from MQTT.")
+		return
+	}
 
-	var response *http.Response
+	var body io.Reader
+	switch request.Method {
+	case "POST":
+		body = bytes.NewBuffer([]byte(request.PostData))
+	case "GET":
+	default:
+		logger.Warn().Msgf("Ignoring MQTT request with unsupported method '%s'", request.Method)
+		return
+	}
 
+	req, err := http.NewRequest(request.Method, fmt.Sprintf("http://localhost:5353%s", request.Path), body)
+	if err != nil {
+		logger.Error().Err(err).Msg("Could not forward request from websocket.")
+		return
+	}
 	if request.Method == "POST" {
-		jsonStr := []byte(request.PostData)
-		req, err := http.NewRequest("POST", fmt.Sprintf("http://localhost:5353%s", request.Path), bytes.NewBuffer(jsonStr))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	core.SetToken(req)
+	core.SetRequestID(ctx, req)
+
+	go func() {
+		response, err := http.DefaultClient.Do(req)
 		if err != nil {
-			log.Error().Err(err).Msg("Could not forward request from websocket.")
+			logger.Error().Err(err).Msg("Could not forward request from websocket.")
 			return
 		}
-		req.Header.Set("Content-Type", "application/json")
-		httpClient := &http.Client{}
-		go func() {
-			response, err = httpClient.Do(req)
-			if err != nil {
-				log.Error().Err(err).Msg("Could not forward request from websocket.")
-				return
-			}
-			if response != nil {
-				response.Body.Close()
-			}
-		}()
-	} else if request.Method == "GET" {
-		go func() {
-			response, err = http.Get(fmt.Sprintf("http://localhost:5353%s", request.Path))
-			if err != nil {
-				log.Error().Err(err).Msg("Could not forward request from websocket.")
-				return
-			}
-			if response != nil {
-				response.Body.Close()
-			}
-		}()
-	}
+		logger.Info().Msgf("Forwarded MQTT request %s %s: %s", request.Method, request.Path, response.Status)
+		response.Body.Close()
+	}()
 }
 
 // Publish writes the given message to the given topic and wait
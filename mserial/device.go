@@ -1,11 +1,13 @@
 package mserial
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/qcasey/mdroid/pkg/core"
 	"github.com/rs/zerolog/log"
 	"github.com/tarm/serial"
 )
@@ -57,7 +59,7 @@ func (d *Device) begin() {
 }
 
 // write pushes out a message to the open serial port
-func (d *Device) write(msg string) error {
+func (d *Device) write(ctx context.Context, msg string) error {
 	if len(msg) == 0 {
 		return fmt.Errorf("Empty message, not writing to serial")
 	}
@@ -69,7 +71,7 @@ func (d *Device) write(msg string) error {
 	// Add this item to the write queue
 	newID := uuid.New()
 	newAwaitChannel := make(chan bool, 1)
-	newItem := writeQueueItem{message: msg, isConfirmed: &newAwaitChannel, id: newID}
+	newItem := writeQueueItem{message: msg, isConfirmed: &newAwaitChannel, id: newID, log: core.Log(ctx)}
 	d.writeQueueLock.Lock()
 	d.writeQueue[newID] = &newItem
 	d.writeQueueLock.Unlock()
@@ -1,6 +1,7 @@
 package mserial
 
 import (
+	"context"
 	logger "log"
 	"time"
 
@@ -58,6 +59,13 @@ func Start(srv *server.Server) {
 
 // Await queues a message for writing, and waits for it to be sent
 func Await(msg string) {
+	AwaitContext(context.Background(), msg)
+}
+
+// AwaitContext queues a message for writing and waits for it to be sent,
+// logging it with the context's correlation ID
+func AwaitContext(ctx context.Context, msg string) {
+	log := core.Log(ctx)
 	if len(devices) == 0 {
 		log.Error().Msgf("No serial devices configured to handle message: %s", msg)
 		return
@@ -73,7 +81,7 @@ func Await(msg string) {
 			}
 		}
 
-		err := d.write(msg)
+		err := d.write(ctx, msg)
 		if err != nil {
 			log.Error().Err(err).Msgf("Failed to write to device %s", d.Name)
 		}
@@ -14,7 +14,7 @@ func writeSerial() http.HandlerFunc {
 		if params["command"] == "" {
 			core.WriteNewResponse(&w, r, core.JSONResponse{Output: "Empty command", OK: false})
 		}
-		Await(params["command"])
+		AwaitContext(r.Context(), params["command"])
 		core.WriteNewResponse(&w, r, core.JSONResponse{Output: "OK", OK: true})
 	}
 }
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/qcasey/mdroid/pkg/core"
+)
+
+const usage = `Usage: mdroid config <command> [flags] [file...]
+
+Commands:
+  validate  Check config files for unknown keys, wrong types and deprecated keys
+  migrate   Rewrite deprecated keys to their current names
+
+Without any files, the config MDroid would load and its conf.d overlays are used.
+`
+
+// Run the `mdroid config` command with the given arguments, returning the exit code
+func Run(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	switch args[0] {
+	case "validate":
+		return runValidate(args[1:], os.Stdout)
+	case "migrate":
+		return runMigrate(args[1:], os.Stdout)
+	}
+
+	fmt.Fprint(os.Stderr, usage)
+	return 2
+}
+
+func runValidate(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	files, err := filesToCheck(flags.Args())
+	if err != nil {
+		fmt.Fprintf(out, "%s\n", err.Error())
+		return 1
+	}
+
+	failed := false
+	for i, path := range files {
+		// Files after the first are overlays when using the default config
+		isOverlay := len(flags.Args()) == 0 && i > 0
+		issues, err := ValidateFile(path, isOverlay)
+		if err != nil {
+			fmt.Fprintf(out, "%s: %s\n", path, err.Error())
+			failed = true
+			continue
+		}
+
+		if len(issues) == 0 {
+			fmt.Fprintf(out, "%s: OK\n", path)
+			continue
+		}
+		for _, issue := range issues {
+			fmt.Fprintf(out, "%s: %s\n", path, issue)
+			if !issue.Warning {
+				failed = true
+			}
+		}
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+func runMigrate(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only show which keys would be rewritten")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	files, err := filesToCheck(flags.Args())
+	if err != nil {
+		fmt.Fprintf(out, "%s\n", err.Error())
+		return 1
+	}
+
+	failed := false
+	for _, path := range files {
+		changes, err := MigrateFile(path, *dryRun)
+		if err != nil {
+			fmt.Fprintf(out, "%s: %s\n", path, err.Error())
+			failed = true
+			continue
+		}
+
+		if len(changes) == 0 {
+			fmt.Fprintf(out, "%s: nothing to migrate\n", path)
+			continue
+		}
+		for _, change := range changes {
+			fmt.Fprintf(out, "%s: %s\n", path, change)
+		}
+		if !*dryRun {
+			fmt.Fprintf(out, "%s: migrated, original kept at %s.bak\n", path, path)
+		}
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// filesToCheck returns the given files, or the config MDroid would load by default
+func filesToCheck(args []string) ([]string, error) {
+	if len(args) > 0 {
+		return args, nil
+	}
+	return core.ConfigFiles()
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// migration renames a deprecated key in place. A * in the path matches every list item or map key
+type migration struct {
+	path    string
+	newName string
+}
+
+// migrations lists older key names, and the names now read by MDroid.
+// Older configs named connection keys after the fields they were read into
+var migrations = []migration{
+	{path: "mqtt.connections.*.clientid", newName: "client_id"},
+	{path: "mserial.connections.*.name", newName: "device"},
+}
+
+// Change is a single key rewritten by a migration
+type Change struct {
+	Path    string
+	NewName string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s -> %s", c.Path, c.NewName)
+}
+
+// checkDeprecated warns about every deprecated key in the given settings
+func checkDeprecated(settings map[interface{}]interface{}) []Issue {
+	var issues []Issue
+	for _, m := range migrations {
+		segments := strings.Split(m.path, ".")
+		for _, path := range findKeys("", settings, segments) {
+			issues = append(issues, Issue{
+				Path:    path,
+				Message: fmt.Sprintf("is deprecated, use %q instead (run `mdroid config migrate`)", m.newName),
+				Warning: true,
+			})
+		}
+	}
+	return issues
+}
+
+// findKeys lists the full paths of keys matching the given segments
+func findKeys(prefix string, value interface{}, segments []string) []string {
+	if len(segments) == 0 {
+		return []string{prefix}
+	}
+
+	var found []string
+	switch vv := value.(type) {
+	case map[interface{}]interface{}:
+		for k, v := range vv {
+			if segments[0] == "*" || strings.EqualFold(fmt.Sprintf("%v", k), segments[0]) {
+				found = append(found, findKeys(joinPath(prefix, k), v, segments[1:])...)
+			}
+		}
+	case []interface{}:
+		if segments[0] == "*" {
+			for i, item := range vv {
+				found = append(found, findKeys(fmt.Sprintf("%s[%d]", prefix, i), item, segments[1:])...)
+			}
+		}
+	}
+	return found
+}
+
+// MigrateFile rewrites deprecated keys in a config file to their current names.
+// The original file is kept alongside with a .bak suffix
+func MigrateFile(path string, dryRun bool) ([]Change, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// MapSlice keeps the file's key order intact
+	var settings yaml.MapSlice
+	if err := yaml.Unmarshal(contents, &settings); err != nil {
+		return nil, fmt.Errorf("invalid YAML: %s", err.Error())
+	}
+
+	var changes []Change
+	for _, m := range migrations {
+		segments := strings.Split(m.path, ".")
+		renamed, err := rename("", settings, segments, m.newName)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, renamed...)
+	}
+
+	if dryRun || len(changes) == 0 {
+		return changes, nil
+	}
+
+	migrated, err := yaml.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path+".bak", contents, 0644); err != nil {
+		return nil, fmt.Errorf("failed to back up %s: %s", path, err.Error())
+	}
+	return changes, ioutil.WriteFile(path, migrated, 0644)
+}
+
+// rename every key matching the given segments, refusing to overwrite a key that already exists
+func rename(prefix string, value interface{}, segments []string, newName string) ([]Change, error) {
+	var changes []Change
+	switch vv := value.(type) {
+	case yaml.MapSlice:
+		for i := range vv {
+			key := fmt.Sprintf("%v", vv[i].Key)
+			if segments[0] != "*" && !strings.EqualFold(key, segments[0]) {
+				continue
+			}
+			path := joinPath(prefix, key)
+
+			if len(segments) > 1 {
+				renamed, err := rename(path, vv[i].Value, segments[1:], newName)
+				if err != nil {
+					return nil, err
+				}
+				changes = append(changes, renamed...)
+				continue
+			}
+
+			for _, sibling := range vv {
+				if strings.EqualFold(fmt.Sprintf("%v", sibling.Key), newName) {
+					return nil, fmt.Errorf("cannot rename %s, %s already exists", path, joinPath(prefix, newName))
+				}
+			}
+			vv[i].Key = newName
+			changes = append(changes, Change{Path: path, NewName: newName})
+		}
+	case []interface{}:
+		if segments[0] != "*" {
+			return nil, nil
+		}
+		for i, item := range vv {
+			renamed, err := rename(fmt.Sprintf("%s[%d]", prefix, i), item, segments[1:], newName)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, renamed...)
+		}
+	}
+	return changes, nil
+}
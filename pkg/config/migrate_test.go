@@ -0,0 +1,90 @@
+package config
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestMigrateFile(t *testing.T) {
+	config := `mqtt:
+  enabled: true
+  connections:
+  - address: tcp://localhost:1883
+    clientid: mdroid
+  - address: tcp://remote:1883
+    ClientID: remote
+mserial:
+  connections:
+  - name: /dev/ttyUSB0
+    baud: 115200
+`
+	path := writeConfig(t, config)
+
+	want := []Change{
+		{Path: "mqtt.connections[0].clientid", NewName: "client_id"},
+		{Path: "mqtt.connections[1].ClientID", NewName: "client_id"},
+		{Path: "mserial.connections[0].name", NewName: "device"},
+	}
+
+	// A dry run only reports what would change
+	changes, err := MigrateFile(path, true)
+	if err != nil {
+		t.Fatalf("failed to migrate: %s", err.Error())
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("MigrateFile() = %v, want %v", changes, want)
+	}
+	if contents, _ := ioutil.ReadFile(path); string(contents) != config {
+		t.Fatalf("a dry run rewrote the file:\n%s", contents)
+	}
+
+	changes, err = MigrateFile(path, false)
+	if err != nil {
+		t.Fatalf("failed to migrate: %s", err.Error())
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("MigrateFile() = %v, want %v", changes, want)
+	}
+
+	// Keys are renamed in place, and the original file is kept
+	migrated := `mqtt:
+  enabled: true
+  connections:
+  - address: tcp://localhost:1883
+    client_id: mdroid
+  - address: tcp://remote:1883
+    client_id: remote
+mserial:
+  connections:
+  - device: /dev/ttyUSB0
+    baud: 115200
+`
+	if contents, _ := ioutil.ReadFile(path); string(contents) != migrated {
+		t.Errorf("migrated file is\n%s\nwant\n%s", contents, migrated)
+	}
+	if backup, _ := ioutil.ReadFile(path + ".bak"); string(backup) != config {
+		t.Errorf("backup is\n%s\nwant\n%s", backup, config)
+	}
+	if issues, err := ValidateFile(path, false); err != nil || len(issues) != 0 {
+		t.Errorf("migrated file has issues %v, %v", issues, err)
+	}
+
+	// Migrating again has nothing left to do
+	changes, err = MigrateFile(path, false)
+	if err != nil || len(changes) != 0 {
+		t.Errorf("MigrateFile() = %v, %v on a migrated file, want no changes", changes, err)
+	}
+}
+
+func TestMigrateFileConflict(t *testing.T) {
+	config := "mqtt:\n  connections:\n  - address: tcp://localhost:1883\n    clientid: old\n    client_id: new\n"
+	path := writeConfig(t, config)
+
+	if _, err := MigrateFile(path, false); err == nil {
+		t.Error("migrated over an existing key without an error")
+	}
+	if contents, _ := ioutil.ReadFile(path); string(contents) != config {
+		t.Errorf("a failed migration rewrote the file:\n%s", contents)
+	}
+}
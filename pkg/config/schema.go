@@ -0,0 +1,222 @@
+// Package config validates and migrates MDroid config files against the settings each module reads
+package config
+
+import "strings"
+
+// kind of value a setting expects
+type kind int
+
+const (
+	anyKind kind = iota
+	boolKind
+	stringKind
+	intKind
+	numberKind
+	durationKind
+	listKind
+	objectKind
+	mapKind
+)
+
+func (k kind) String() string {
+	switch k {
+	case boolKind:
+		return "bool"
+	case stringKind:
+		return "string"
+	case intKind:
+		return "integer"
+	case numberKind:
+		return "number"
+	case durationKind:
+		return "duration"
+	case listKind:
+		return "list"
+	case objectKind, mapKind:
+		return "map"
+	}
+	return "any"
+}
+
+// field describes a known setting
+type field struct {
+	kind kind
+	// fields of an object
+	fields map[string]*field
+	// elem describes each item of a list, or each value of a map
+	elem *field
+	// enum restricts a string to the given values, ignoring case
+	enum []string
+	// required fields must be present in their parent object
+	required bool
+	// positive durations can't be 0, i.e. intervals used for tickers
+	positive bool
+}
+
+func boolean() *field                   { return &field{kind: boolKind} }
+func str() *field                       { return &field{kind: stringKind} }
+func integer() *field                   { return &field{kind: intKind} }
+func number() *field                    { return &field{kind: numberKind} }
+func duration() *field                  { return &field{kind: durationKind} }
+func anything() *field                  { return &field{kind: anyKind} }
+func list(elem *field) *field           { return &field{kind: listKind, elem: elem} }
+func mapOf(elem *field) *field          { return &field{kind: mapKind, elem: elem} }
+func object(f map[string]*field) *field { return &field{kind: objectKind, fields: f} }
+func enum(values ...string) *field      { return &field{kind: stringKind, enum: values} }
+
+func (f *field) require() *field {
+	f.required = true
+	return f
+}
+
+func (f *field) nonZero() *field {
+	f.positive = true
+	return f
+}
+
+func (f *field) durationBound() string {
+	if f.positive {
+		return "greater than 0"
+	}
+	return "0 or more"
+}
+
+func (f *field) allows(value string) bool {
+	if len(f.enum) == 0 {
+		return true
+	}
+	for _, allowed := range f.enum {
+		if strings.EqualFold(allowed, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// module settings that every module shares
+func module(f map[string]*field) *field {
+	f["enabled"] = boolean()
+	return object(f)
+}
+
+// schema lists every setting read by MDroid core and its modules
+var schema = object(map[string]*field{
+	"mdroid": object(map[string]*field{
+		"debug":  boolean(),
+		"socket": str(),
+		"token":  str(),
+	}),
+	"components": mapOf(enum("ON", "OFF", "AUTO")),
+	"kbus": module(map[string]*field{
+		"device":            str(),
+		"translation_table": str(),
+		"replay_speed":      number(),
+		"replay_loop":       boolean(),
+		"write_spacing":     duration(),
+		"polls": list(object(map[string]*field{
+			"command":  str().require(),
+			"interval": duration().nonZero().require(),
+			"boost":    duration(),
+			"when":     mapOf(anything()),
+		})),
+		"poll_boost_duration": duration(),
+		"cdc": object(map[string]*field{
+			"enabled": boolean(),
+		}),
+		"gestures": object(map[string]*field{
+			"long_press":   duration(),
+			"double_press": duration(),
+		}),
+		"displays": mapOf(object(map[string]*field{
+			"source":      str(),
+			"destination": str(),
+			"header":      str(),
+			"width":       integer(),
+		})),
+		"display_scroll_interval": duration().nonZero(),
+		"display_now_playing":     str(),
+		"steering_wheel": list(object(map[string]*field{
+			"name": str(),
+			"when": mapOf(anything()),
+			"buttons": mapOf(object(map[string]*field{
+				"type":    enum("bluetooth", "macro", "serial", "setting", "http").require(),
+				"command": str(),
+				"key":     str(),
+				"method":  str(),
+				"url":     str(),
+			})),
+		})),
+		"macros": mapOf(object(map[string]*field{
+			"when":   mapOf(anything()),
+			"repeat": integer(),
+			"steps": list(object(map[string]*field{
+				"command": str(),
+				"raw":     str(),
+				"delay":   duration(),
+				"repeat":  integer(),
+			})),
+		})),
+	}),
+	"can": module(map[string]*field{
+		"device":       str(),
+		"dbc":          list(str()),
+		"topics":       mapOf(str()),
+		"replay_speed": number(),
+		"replay_loop":  boolean(),
+		"obd": object(map[string]*field{
+			"enabled":   boolean(),
+			"pids":      list(str()),
+			"interval":  duration().nonZero(),
+			"intervals": mapOf(duration().nonZero()),
+		}),
+		"periodic": list(object(map[string]*field{
+			"name":     str(),
+			"id":       str().require(),
+			"extended": boolean(),
+			"data":     str(),
+			"interval": duration().nonZero().require(),
+			"when":     mapOf(anything()),
+			"unless":   mapOf(anything()),
+		})),
+	}),
+	"mqtt": module(map[string]*field{
+		"connections": list(object(map[string]*field{
+			"address":   str().require(),
+			"client_id": str(),
+			"username":  str(),
+			"password":  str(),
+			"verbose":   boolean(),
+		})),
+		"verbose_topics": list(str()),
+	}),
+	"mserial": module(map[string]*field{
+		"connections": list(object(map[string]*field{
+			"device":   str().require(),
+			"baud":     integer().require(),
+			"iswriter": boolean(),
+		})),
+	}),
+	"bluetooth": module(map[string]*field{
+		"address":  str(),
+		"profiles": list(str()),
+	}),
+	"camera": module(map[string]*field{
+		"toggledon": boolean(),
+		"devices": mapOf(object(map[string]*field{
+			"destination": str(),
+		})),
+	}),
+	"enginesound": module(map[string]*field{
+		"socket":    str(),
+		"toggledon": boolean(),
+	}),
+	"artwork": module(map[string]*field{
+		"directory": str(),
+	}),
+	"prometheus": module(map[string]*field{}),
+	"diagnostics": module(map[string]*field{
+		"interval":     duration().nonZero(),
+		"code_table":   str(),
+		"history_file": str(),
+	}),
+})
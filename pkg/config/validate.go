@@ -0,0 +1,244 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Issue is a single problem found in a config file
+type Issue struct {
+	Path    string
+	Message string
+	// Warnings don't stop MDroid from starting, but are likely mistakes
+	Warning bool
+}
+
+func (i Issue) String() string {
+	level := "error"
+	if i.Warning {
+		level = "warning"
+	}
+	if i.Path == "" {
+		return fmt.Sprintf("%s: %s", level, i.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", level, i.Path, i.Message)
+}
+
+// ValidateFile checks a config file against the known settings schema.
+// Overlays are partial, so they may leave out required settings
+func ValidateFile(path string, isOverlay bool) ([]Issue, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var settings map[interface{}]interface{}
+	if err := yaml.Unmarshal(contents, &settings); err != nil {
+		return []Issue{{Message: fmt.Sprintf("invalid YAML: %s", err.Error())}}, nil
+	}
+
+	issues := checkDeprecated(settings)
+	deprecated := make(map[string]bool, len(issues))
+	for _, issue := range issues {
+		deprecated[issue.Path] = true
+	}
+
+	// Deprecated keys are already reported, don't report them as unknown too
+	for _, issue := range validate("", settings, schema, isOverlay) {
+		if !deprecated[issue.Path] {
+			issues = append(issues, issue)
+		}
+	}
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].Path < issues[j].Path })
+	return issues, nil
+}
+
+// validate a value against its schema field. Overlays only hold part of the
+// config, so partial objects skip their required fields
+func validate(path string, value interface{}, f *field, partial bool) []Issue {
+	var issues []Issue
+	mismatch := func() []Issue {
+		return []Issue{{Path: path, Message: fmt.Sprintf("expected %s, got %v", f.kind, describe(value))}}
+	}
+
+	switch f.kind {
+	case boolKind:
+		if _, ok := value.(bool); !ok {
+			return mismatch()
+		}
+
+	case stringKind:
+		s, ok := value.(string)
+		if !ok {
+			// Plain scalars are read as strings just fine
+			if !isScalar(value) {
+				return mismatch()
+			}
+			s = fmt.Sprintf("%v", value)
+		}
+		if !f.allows(s) {
+			return []Issue{{Path: path, Message: fmt.Sprintf("%q must be one of %s", s, strings.Join(f.enum, ", "))}}
+		}
+
+	case intKind:
+		if _, ok := value.(int); !ok {
+			return mismatch()
+		}
+
+	case numberKind:
+		switch value.(type) {
+		case int, float64:
+		default:
+			return mismatch()
+		}
+
+	case durationKind:
+		d, ok := parseDuration(value)
+		if !ok {
+			return mismatch()
+		}
+		if d < 0 || (f.positive && d == 0) {
+			return []Issue{{Path: path, Message: fmt.Sprintf("%v must be %s", value, f.durationBound())}}
+		}
+
+	case listKind:
+		items, ok := value.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		for i, item := range items {
+			issues = append(issues, validate(fmt.Sprintf("%s[%d]", path, i), item, f.elem, partial)...)
+		}
+
+	case mapKind:
+		m, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return mismatch()
+		}
+		for k, v := range m {
+			issues = append(issues, validate(joinPath(path, k), v, f.elem, partial)...)
+		}
+
+	case objectKind:
+		m, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return mismatch()
+		}
+
+		present := make(map[string]bool)
+		for k, v := range m {
+			name := strings.ToLower(fmt.Sprintf("%v", k))
+			present[name] = true
+
+			known, ok := f.fields[name]
+			if !ok {
+				issues = append(issues, unknownKey(joinPath(path, k), name, f))
+				continue
+			}
+			issues = append(issues, validate(joinPath(path, k), v, known, partial)...)
+		}
+
+		if !partial {
+			for name, known := range f.fields {
+				if known.required && !present[name] {
+					issues = append(issues, Issue{Path: joinPath(path, name), Message: "is required"})
+				}
+			}
+		}
+	}
+
+	return issues
+}
+
+// unknownKey reports a key missing from the schema, suggesting the closest known key
+func unknownKey(path string, name string, parent *field) Issue {
+	best, bestDistance := "", 3
+	for known := range parent.fields {
+		if d := distance(name, known); d < bestDistance || (d == bestDistance && known < best) {
+			best, bestDistance = known, d
+		}
+	}
+
+	if best != "" {
+		return Issue{Path: path, Message: fmt.Sprintf("unknown key, did you mean %q?", best)}
+	}
+	return Issue{Path: path, Message: "unknown key"}
+}
+
+// distance is the Levenshtein edit distance between two keys
+func distance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func isScalar(value interface{}) bool {
+	switch value.(type) {
+	case string, bool, int, int64, uint64, float64:
+		return true
+	}
+	return false
+}
+
+// parseDuration reads a value the way viper's GetDuration does, where plain numbers are nanoseconds
+func parseDuration(value interface{}) (time.Duration, bool) {
+	switch vv := value.(type) {
+	case int:
+		return time.Duration(vv), true
+	case string:
+		if d, err := time.ParseDuration(vv); err == nil {
+			return d, true
+		}
+		n, err := strconv.Atoi(vv)
+		return time.Duration(n), err == nil
+	}
+	return 0, false
+}
+
+func describe(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "nothing"
+	case map[interface{}]interface{}:
+		return "a map"
+	case []interface{}:
+		return "a list"
+	case string:
+		return fmt.Sprintf("string %q", value)
+	}
+	return fmt.Sprintf("%T %v", value, value)
+}
+
+func joinPath(prefix string, key interface{}) string {
+	if prefix == "" {
+		return fmt.Sprintf("%v", key)
+	}
+	return fmt.Sprintf("%s.%v", prefix, key)
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a config file to a temporary directory, returning its path
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestValidateFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		overlay bool
+		want    []Issue
+	}{
+		{"valid", `
+mdroid:
+  debug: true
+components:
+  kbus: auto
+kbus:
+  enabled: true
+  device: /dev/ttyUSB0
+mqtt:
+  connections:
+    - address: tcp://localhost:1883
+      client_id: mdroid
+`, false, nil},
+		{"unknown key", "bluetooth:\n  adress: AA:BB:CC:DD:EE:FF\n", false, []Issue{
+			{Path: "bluetooth.adress", Message: `unknown key, did you mean "address"?`},
+		}},
+		{"unknown module", "spotify:\n  enabled: true\n", false, []Issue{
+			{Path: "spotify", Message: "unknown key"},
+		}},
+		{"wrong types", "kbus:\n  enabled: yes please\nmserial:\n  connections:\n    - device: /dev/ttyUSB0\n      baud: fast\n", false, []Issue{
+			{Path: "kbus.enabled", Message: `expected bool, got string "yes please"`},
+			{Path: "mserial.connections[0].baud", Message: `expected integer, got string "fast"`},
+		}},
+		{"enum", "components:\n  kbus: sometimes\n", false, []Issue{
+			{Path: "components.kbus", Message: `"sometimes" must be one of ON, OFF, AUTO`},
+		}},
+		{"required", "mserial:\n  connections:\n    - device: /dev/ttyUSB0\n", false, []Issue{
+			{Path: "mserial.connections[0].baud", Message: "is required"},
+		}},
+		{"overlay skips required", "mserial:\n  connections:\n    - device: /dev/ttyUSB0\n", true, nil},
+		{"deprecated key", "mqtt:\n  connections:\n    - address: tcp://localhost:1883\n      clientid: mdroid\n", false, []Issue{
+			{Path: "mqtt.connections[0].clientid", Message: `is deprecated, use "client_id" instead (run ` + "`mdroid config migrate`)", Warning: true},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues, err := ValidateFile(writeConfig(t, tt.config), tt.overlay)
+			if err != nil {
+				t.Fatalf("failed to validate: %s", err.Error())
+			}
+			if !reflect.DeepEqual(issues, tt.want) {
+				t.Errorf("ValidateFile() = %v, want %v", issues, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateDuration(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		f     *field
+		want  []Issue
+	}{
+		{"45s", duration(), nil},
+		{"0s", duration(), nil},
+		{"soon", duration(), []Issue{{Path: "interval", Message: `expected duration, got string "soon"`}}},
+		{"-50ms", duration(), []Issue{{Path: "interval", Message: "-50ms must be 0 or more"}}},
+		{"45s", duration().nonZero(), nil},
+		{"0s", duration().nonZero(), []Issue{{Path: "interval", Message: "0s must be greater than 0"}}},
+		{"-1s", duration().nonZero(), []Issue{{Path: "interval", Message: "-1s must be greater than 0"}}},
+	}
+
+	for _, tt := range tests {
+		if issues := validate("interval", tt.value, tt.f, false); !reflect.DeepEqual(issues, tt.want) {
+			t.Errorf("validate(%q) = %v, want %v", tt.value, issues, tt.want)
+		}
+	}
+}
+
+func TestValidateInvalidYAML(t *testing.T) {
+	issues, err := ValidateFile(writeConfig(t, "kbus: [\n"), false)
+	if err != nil {
+		t.Fatalf("failed to validate: %s", err.Error())
+	}
+	if len(issues) != 1 || issues[0].Warning || !strings.HasPrefix(issues[0].Message, "invalid YAML") {
+		t.Errorf("ValidateFile() = %v, want an invalid YAML error", issues)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"address", "address", 0},
+		{"adress", "address", 1},
+		{"adresss", "address", 2},
+		{"", "port", 4},
+		{"clientid", "client_id", 1},
+	}
+
+	for _, tt := range tests {
+		if got := distance(tt.a, tt.b); got != tt.want {
+			t.Errorf("distance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
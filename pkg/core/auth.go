@@ -0,0 +1,29 @@
+package core
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// TokenHeader carries the API token on requests
+const TokenHeader = "Authorization"
+
+// IsAuthorized checks the request's bearer token against mdroid.token.
+// If no token is configured, every request is authorized
+func IsAuthorized(r *http.Request) bool {
+	token := Settings.Store.GetString("mdroid.token")
+	if token == "" {
+		return true
+	}
+
+	given := strings.TrimPrefix(r.Header.Get(TokenHeader), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// SetToken adds the configured API token to an outgoing request
+func SetToken(r *http.Request) {
+	if token := Settings.Store.GetString("mdroid.token"); token != "" {
+		r.Header.Set(TokenHeader, "Bearer "+token)
+	}
+}
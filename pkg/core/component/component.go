@@ -2,11 +2,15 @@ package component
 
 import (
 	"fmt"
+	"net/http"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/qcasey/mdroid/mserial"
 	"github.com/qcasey/mdroid/pkg/core"
+	"github.com/qcasey/mdroid/pkg/server"
 	"github.com/rs/zerolog/log"
 )
 
@@ -21,9 +25,31 @@ type Component struct {
 	TurnOff        func()
 }
 
+// Status describes a component's current state, for the API
+type Status struct {
+	Name             string    `json:"name"`
+	IsOn             bool      `json:"on"`
+	Setting          string    `json:"setting"`
+	ShouldBeOn       bool      `json:"should_be_on"`
+	Reason           string    `json:"reason"`
+	LastChangedState time.Time `json:"last_changed"`
+}
+
+var (
+	registry     = make(map[string]*Component)
+	registryLock sync.Mutex
+)
+
+func register(comp *Component) *Component {
+	registryLock.Lock()
+	registry[comp.Name] = comp
+	registryLock.Unlock()
+	return comp
+}
+
 // NewWithDefaults creates a new default component
 func NewWithDefaults(name string, shouldBeOnWhen func() (bool, string)) *Component {
-	return &Component{
+	return register(&Component{
 		Name: name,
 		Hook: make(chan core.Message, 1),
 
@@ -35,21 +61,57 @@ func NewWithDefaults(name string, shouldBeOnWhen func() (bool, string)) *Compone
 		TurnOff: func() {
 			mserial.Await(fmt.Sprintf("powerOff:%s", strings.ToLower(name)))
 		},
-	}
+	})
 }
 
 // New creates a new default component with custom on/off functions
 func New(name string, shouldBeOnWhen func() (bool, string), on func(), off func()) *Component {
-	return &Component{
+	return register(&Component{
 		Name: name,
 		Hook: make(chan core.Message, 1),
 
 		ShouldBeOnWhen: shouldBeOnWhen,
 		TurnOn:         on,
 		TurnOff:        off,
+	})
+}
+
+// Status reports the component's current and desired state
+func (comp *Component) Status() Status {
+	shouldBeOn, reason := comp.ShouldBeOnWhen()
+	return Status{
+		Name:             comp.Name,
+		IsOn:             core.Session.Store.GetBool(comp.Name),
+		Setting:          strings.ToUpper(core.Settings.Store.GetString(fmt.Sprintf("components.%s", comp.Name))),
+		ShouldBeOn:       shouldBeOn,
+		Reason:           reason,
+		LastChangedState: comp.LastChangedState,
 	}
 }
 
+// All returns the status of every registered component, sorted by name
+func All() []Status {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	statuses := make([]Status, 0, len(registry))
+	for _, comp := range registry {
+		statuses = append(statuses, comp.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// Start serves the status of every component at /components
+func Start(srv *server.Server) {
+	srv.Router.HandleFunc("/components", HandleGetAll).Methods("GET")
+}
+
+// HandleGetAll responds with the status of every registered component
+func HandleGetAll(w http.ResponseWriter, r *http.Request) {
+	core.WriteNewResponse(&w, r, core.JSONResponse{Output: All(), OK: true})
+}
+
 // Evaluate if a component should be on or off, then take that action if it doesn't match the state
 func (comp *Component) Evaluate() {
 	componentIsOn := core.Session.Store.GetBool(comp.Name)
@@ -0,0 +1,342 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/qcasey/viper"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	configName    = "config.yaml"
+	overlayDir    = "conf.d"
+	envPrefix     = "MDROID_"
+	runtimeSource = "runtime"
+)
+
+// configPaths are searched in order for the base config file
+var configPaths = []string{"/etc/mdroid/", "."}
+
+var (
+	sources     map[string]string
+	sourcesLock sync.Mutex
+)
+
+// configLayers holds the result of reading every configuration source
+type configLayers struct {
+	// base is the on-disk config file, runtime changes are written back to it
+	base *viper.Viper
+	// merged is the effective view of all layers
+	merged *viper.Viper
+	// sources maps each effective key to where its value came from
+	sources map[string]string
+	// secrets holds every key whose value was resolved from a secret reference
+	secrets map[string]bool
+	// dir is the directory the base config was found in
+	dir string
+}
+
+// findConfigDir returns the first config path containing a base config file
+func findConfigDir() (string, error) {
+	for _, dir := range configPaths {
+		if _, err := os.Stat(filepath.Join(dir, configName)); err == nil {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("Config File %q Not Found in %v", configName, configPaths)
+}
+
+// ConfigFiles lists the base config file followed by its overlays, in the order they're applied
+func ConfigFiles() ([]string, error) {
+	dir, err := findConfigDir()
+	if err != nil {
+		return nil, err
+	}
+
+	overlays, err := filepath.Glob(filepath.Join(dir, overlayDir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(overlays)
+	return append([]string{filepath.Join(dir, configName)}, overlays...), nil
+}
+
+// loadConfig reads the base config file, layers conf.d/*.yaml overlays on top in
+// lexical order, then finally applies any MDROID_* environment variables
+func loadConfig() (*configLayers, error) {
+	layers := &configLayers{
+		base:    viper.New(),
+		merged:  viper.New(),
+		sources: make(map[string]string),
+	}
+
+	files, err := ConfigFiles()
+	if err != nil {
+		// Still apply the environment, so a missing file isn't fatal
+		layers.applyEnvironment()
+		layers.resolveSecrets()
+		return layers, err
+	}
+	layers.dir = filepath.Dir(files[0])
+
+	layers.base.SetConfigFile(files[0])
+	if err := layers.base.ReadInConfig(); err != nil {
+		return layers, err
+	}
+	layers.merge(layers.base.AllSettings(), files[0])
+
+	for _, path := range files[1:] {
+		overlay := viper.New()
+		overlay.SetConfigFile(path)
+		if err := overlay.ReadInConfig(); err != nil {
+			log.Warn().Err(err).Msgf("Failed to read config overlay %s, skipping", path)
+			continue
+		}
+		layers.merge(overlay.AllSettings(), path)
+	}
+
+	layers.applyEnvironment()
+	layers.resolveSecrets()
+	return layers, nil
+}
+
+// resolveSecrets replaces secret references in the effective view with their values.
+// The base layer keeps the references, so secrets are never written back to disk
+func (layers *configLayers) resolveSecrets() {
+	resolved, secrets := resolveSecrets(layers.merged.AllSettings())
+	layers.merged = viper.New()
+	if err := layers.merged.MergeConfigMap(resolved); err != nil {
+		log.Error().Err(err).Msg("Failed to merge resolved secrets")
+	}
+	layers.secrets = secrets
+}
+
+// merge a layer of settings into the effective view, recording the source of each key
+func (layers *configLayers) merge(settings map[string]interface{}, source string) {
+	if err := layers.merged.MergeConfigMap(settings); err != nil {
+		log.Error().Err(err).Msgf("Failed to merge settings from %s", source)
+		return
+	}
+	for _, key := range flattenKeys("", settings) {
+		layers.sources[key] = source
+	}
+}
+
+// applyEnvironment merges MDROID_* environment variables into the effective view.
+// MDROID_KBUS_DEVICE overrides an existing kbus.device, and a double underscore
+// can be used to nest keys that don't exist yet, i.e. MDROID_CAN__DEVICE or MDROID_MDROID__TOKEN.
+// Clients such as mdroidctl read MDROIDCTL_* instead, so their variables aren't taken as settings
+func (layers *configLayers) applyEnvironment() {
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, envPrefix) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(env, envPrefix), "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			continue
+		}
+
+		key := layers.envToKey(strings.ToLower(parts[0]))
+		layers.merge(nestedMap(key, parts[1]), fmt.Sprintf("env:%s%s", envPrefix, parts[0]))
+	}
+}
+
+// envToKey resolves an environment variable name into a settings key
+func (layers *configLayers) envToKey(name string) string {
+	if strings.Contains(name, "__") {
+		return strings.ReplaceAll(name, "__", ".")
+	}
+	for _, key := range layers.merged.AllKeys() {
+		if strings.ReplaceAll(key, ".", "_") == name {
+			return key
+		}
+	}
+	return strings.Replace(name, "_", ".", 1)
+}
+
+// nestedMap expands a dotted key into nested maps, suitable for merging into viper
+func nestedMap(key string, value interface{}) map[string]interface{} {
+	parts := strings.Split(key, ".")
+	m := map[string]interface{}{parts[len(parts)-1]: value}
+	for i := len(parts) - 2; i >= 0; i-- {
+		m = map[string]interface{}{parts[i]: m}
+	}
+	return m
+}
+
+// flattenKeys lists every leaf key in a nested settings map, in viper's dotted format
+func flattenKeys(prefix string, settings map[string]interface{}) []string {
+	var keys []string
+	for k, v := range settings {
+		key := strings.ToLower(k)
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		switch vv := v.(type) {
+		case map[string]interface{}:
+			keys = append(keys, flattenKeys(key, vv)...)
+		case map[interface{}]interface{}:
+			converted := make(map[string]interface{}, len(vv))
+			for ik, iv := range vv {
+				converted[fmt.Sprintf("%v", ik)] = iv
+			}
+			keys = append(keys, flattenKeys(key, converted)...)
+		default:
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// SettingSources returns where each effective setting value came from,
+// either a config file path, an environment variable, or runtime
+func SettingSources() map[string]string {
+	sourcesLock.Lock()
+	defer sourcesLock.Unlock()
+
+	copied := make(map[string]string, len(sources))
+	for k, v := range sources {
+		copied[k] = v
+	}
+	return copied
+}
+
+func setSources(newSources map[string]string) {
+	sourcesLock.Lock()
+	sources = newSources
+	sourcesLock.Unlock()
+}
+
+func setSource(key string, source string) {
+	sourcesLock.Lock()
+	if sources == nil {
+		sources = make(map[string]string)
+	}
+	sources[strings.ToLower(key)] = source
+	sourcesLock.Unlock()
+}
+
+// watchConfig reloads settings whenever the base config or an overlay changes
+func watchConfig(dir string) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create config watcher")
+		return
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(dir); err != nil {
+		log.Error().Err(err).Msgf("Failed to watch config directory %s", dir)
+		return
+	}
+	if err := watcher.Add(filepath.Join(dir, overlayDir)); err != nil {
+		log.Debug().Err(err).Msgf("Not watching config overlays in %s", dir)
+	}
+	log.Info().Msgf("Watching %s for config changes", dir)
+
+	// Editors tend to write files in several steps, wait for them to settle
+	var debounce <-chan time.Time
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Ext(event.Name) != ".yaml" {
+				continue
+			}
+			debounce = time.After(500 * time.Millisecond)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Error().Err(err).Msg("Config watcher failed")
+		case <-debounce:
+			debounce = nil
+			reloadConfig()
+		}
+	}
+}
+
+// reloadConfig reads every config source again, and publishes the keys that changed.
+// Keys are compared against the sources as last read rather than the current settings,
+// so values set at runtime (and mdroid's own writes to config.yaml) aren't reverted
+func reloadConfig() {
+	layers, err := loadConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to reload config, keeping current settings")
+		return
+	}
+
+	Settings.mutex.Lock()
+	previous := Settings.loaded
+	Settings.mutex.Unlock()
+
+	changed := 0
+	for _, key := range layers.merged.AllKeys() {
+		newValue := layers.merged.Get(key)
+		if previous != nil && previous.IsSet(key) && sameValue(previous.Get(key), newValue) {
+			continue
+		}
+		if Settings.Store.IsSet(key) && sameValue(Settings.Store.Get(key), newValue) {
+			continue
+		}
+		log.Info().Msgf("Setting %s changed on disk", key)
+		Settings.publish(key, newValue, false)
+		changed++
+	}
+
+	oldSources := SettingSources()
+	for _, key := range Settings.Store.AllKeys() {
+		if _, ok := layers.sources[key]; !ok && oldSources[key] != runtimeSource {
+			log.Warn().Msgf("Setting %s was removed from config, keeping its current value until restart", key)
+		}
+	}
+
+	// Values set at runtime still win over the files they were read from
+	for key, source := range oldSources {
+		if source == runtimeSource {
+			layers.sources[key] = runtimeSource
+		}
+	}
+
+	Settings.useLayers(layers)
+	if changed > 0 {
+		log.Info().Msgf("Reloaded config, %d settings changed", changed)
+	}
+}
+
+// useLayers swaps in newly read config sources
+func (ds *Datastore) useLayers(layers *configLayers) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	// Settings.Store starts out as the merged view, keep a copy that runtime changes don't touch
+	loaded := viper.New()
+	if err := loaded.MergeConfigMap(layers.merged.AllSettings()); err != nil {
+		log.Error().Err(err).Msg("Failed to copy loaded config")
+	}
+
+	ds.disk = layers.base
+	ds.loaded = loaded
+	setSources(layers.sources)
+	setSecretKeys(layers.secrets)
+}
+
+// sameValue compares settings as text, since values set at runtime are strings
+// while the same values read from YAML are bools and numbers
+func sameValue(a interface{}, b interface{}) bool {
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+	return strings.EqualFold(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+}
@@ -0,0 +1,189 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/qcasey/viper"
+)
+
+// useConfigDir writes the given files to a temporary directory and reads config from it
+func useConfigDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "mdroid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, overlayDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		writeFile(t, filepath.Join(dir, name), contents)
+	}
+
+	previous := configPaths
+	configPaths = []string{dir}
+	t.Cleanup(func() {
+		configPaths = previous
+		os.RemoveAll(dir)
+	})
+	return dir
+}
+
+func writeFile(t *testing.T, path string, contents string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newLayer reads a single layer of YAML settings
+func newLayer(t *testing.T, contents string) *viper.Viper {
+	t.Helper()
+	layer := viper.New()
+	layer.SetConfigType("yaml")
+	if err := layer.ReadConfig(strings.NewReader(contents)); err != nil {
+		t.Fatal(err)
+	}
+	return layer
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := useConfigDir(t, map[string]string{
+		configName: `
+kbus:
+  device: /dev/ttyUSB0
+  write_spacing: 10ms
+mqtt:
+  enabled: false
+bluetooth:
+  address: AA:BB:CC:DD:EE:FF
+`,
+		"conf.d/10-car.yaml":   "kbus:\n  write_spacing: 20ms\nmqtt:\n  enabled: true\n",
+		"conf.d/20-bench.yaml": "kbus:\n  write_spacing: 30ms\n",
+		"conf.d/notes.txt":     "kbus:\n  write_spacing: 40ms\n",
+	})
+	t.Setenv("MDROID_KBUS_DEVICE", "/dev/ttyUSB1")
+	t.Setenv("MDROID_CAN__DEVICE", "can0")
+
+	layers, err := loadConfig()
+	if err != nil {
+		t.Fatalf("failed to load config: %s", err.Error())
+	}
+
+	base := filepath.Join(dir, configName)
+	tests := []struct {
+		key    string
+		value  interface{}
+		source string
+	}{
+		{"bluetooth.address", "AA:BB:CC:DD:EE:FF", base},
+		{"mqtt.enabled", true, filepath.Join(dir, overlayDir, "10-car.yaml")},
+		{"kbus.write_spacing", "30ms", filepath.Join(dir, overlayDir, "20-bench.yaml")},
+		{"kbus.device", "/dev/ttyUSB1", "env:MDROID_KBUS_DEVICE"},
+		{"can.device", "can0", "env:MDROID_CAN__DEVICE"},
+	}
+	for _, tt := range tests {
+		if got := layers.merged.Get(tt.key); got != tt.value {
+			t.Errorf("%s = %v, want %v", tt.key, got, tt.value)
+		}
+		if got := layers.sources[tt.key]; got != tt.source {
+			t.Errorf("%s came from %s, want %s", tt.key, got, tt.source)
+		}
+	}
+
+	// Only the base config is written back to
+	if got := layers.base.GetString("kbus.write_spacing"); got != "10ms" {
+		t.Errorf("base kbus.write_spacing = %s, want 10ms", got)
+	}
+	if layers.base.IsSet("can.device") {
+		t.Error("the environment was merged into the base config")
+	}
+}
+
+func TestEnvToKey(t *testing.T) {
+	layers := &configLayers{merged: newLayer(t, "kbus:\n  write_spacing: 10ms\n  device: /dev/ttyUSB0\n")}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"kbus_device", "kbus.device"},
+		{"kbus_write_spacing", "kbus.write_spacing"},
+		{"kbus_macro_spacing", "kbus.macro_spacing"},
+		{"mdroid__token", "mdroid.token"},
+		{"can__obd__interval", "can.obd.interval"},
+	}
+	for _, tt := range tests {
+		if got := layers.envToKey(tt.name); got != tt.want {
+			t.Errorf("envToKey(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNestedMap(t *testing.T) {
+	want := map[string]interface{}{
+		"can": map[string]interface{}{
+			"obd": map[string]interface{}{"interval": "5s"},
+		},
+	}
+	if got := nestedMap("can.obd.interval", "5s"); !reflect.DeepEqual(got, want) {
+		t.Errorf("nestedMap() = %v, want %v", got, want)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	dir := useConfigDir(t, map[string]string{
+		configName:           "kbus:\n  device: /dev/ttyUSB0\n",
+		"conf.d/10-car.yaml": "mqtt:\n  enabled: false\nbluetooth:\n  address: AA:BB:CC:DD:EE:FF\n",
+	})
+	previous := Settings
+	Settings = NewDatastore(true)
+	t.Cleanup(func() { Settings = previous })
+
+	layers, err := loadConfig()
+	if err != nil {
+		t.Fatalf("failed to load config: %s", err.Error())
+	}
+	Settings.Store = layers.merged
+	Settings.useLayers(layers)
+
+	// Set at runtime, which is written back to the base config even though an overlay still wins on disk
+	Settings.Publish("mqtt.enabled", "true")
+
+	writeFile(t, filepath.Join(dir, overlayDir, "10-car.yaml"), "mqtt:\n  enabled: false\nbluetooth:\n  address: 11:22:33:44:55:66\n")
+	writeFile(t, filepath.Join(dir, overlayDir, "20-bench.yaml"), "kbus:\n  device: /dev/ttyUSB1\n")
+	reloadConfig()
+
+	tests := []struct {
+		key    string
+		value  string
+		source string
+	}{
+		{"mqtt.enabled", "true", runtimeSource},
+		{"bluetooth.address", "11:22:33:44:55:66", filepath.Join(dir, overlayDir, "10-car.yaml")},
+		{"kbus.device", "/dev/ttyUSB1", filepath.Join(dir, overlayDir, "20-bench.yaml")},
+	}
+	sources := SettingSources()
+	for _, tt := range tests {
+		if got := Settings.Store.GetString(tt.key); got != tt.value {
+			t.Errorf("%s = %s after reloading, want %s", tt.key, got, tt.value)
+		}
+		if got := sources[tt.key]; got != tt.source {
+			t.Errorf("%s came from %s after reloading, want %s", tt.key, got, tt.source)
+		}
+	}
+
+	// Overlays still aren't written back to the base config
+	contents, err := ioutil.ReadFile(filepath.Join(dir, configName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base := newLayer(t, string(contents)); base.GetString("kbus.device") != "/dev/ttyUSB0" || base.IsSet("bluetooth.address") {
+		t.Errorf("base config was rewritten with overlay values:\n%s", contents)
+	}
+}
@@ -25,19 +25,23 @@ func New() {
 	Session = NewDatastore(false)
 	StartTime = time.Now()
 
-	Settings.Store.SetConfigName("config") // name of config file (without extension)
-	Settings.Store.SetConfigType("yaml")
-	Settings.Store.AddConfigPath("/etc/mdroid/")
-	Settings.Store.AddConfigPath(".")    // optionally look for config in the working directory
-	err := Settings.Store.ReadInConfig() // Find and read the config file
+	// Read the base config, conf.d overlays and environment into one view
+	layers, err := loadConfig()
 	if err != nil {
 		log.Warn().Err(err).Msg("Failed to read config")
 	}
+	Settings.Store = layers.merged
+	Settings.useLayers(layers)
+
+	// Pick up external edits to the config
+	if layers.dir != "" {
+		go watchConfig(layers.dir)
+	}
 
 	// Enable debugging from settings
 	configureLogging(Settings.Store.GetBool("mdroid.debug"))
 
-	log.Info().Msgf("Settings (core): %v", Settings.Store.AllSettings())
+	log.Info().Msgf("Settings (core): %v", RedactedSettings())
 }
 
 // Flush all settings, triggering their respective hooks
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// RequestIDHeader carries a correlation ID from the entry point of a request
+// (HTTP or MQTT) through to the hardware writes it causes
+const RequestIDHeader = "X-Request-ID"
+
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+// NewRequestID creates a new correlation ID
+func NewRequestID() string {
+	return uuid.New().String()
+}
+
+// WithRequestID attaches a correlation ID to the context, along with a logger that includes it
+func WithRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey, id)
+	logger := log.Logger.With().Str("request_id", id).Logger()
+	return logger.WithContext(ctx)
+}
+
+// RequestID returns the correlation ID of the context, if there is one
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// Log returns a logger tagged with the context's correlation ID,
+// falling back to the global logger when there isn't one
+func Log(ctx context.Context) *zerolog.Logger {
+	if RequestID(ctx) == "" {
+		return &log.Logger
+	}
+	return zerolog.Ctx(ctx)
+}
+
+// SetRequestID forwards the context's correlation ID on an outgoing request
+func SetRequestID(ctx context.Context, r *http.Request) {
+	if id := RequestID(ctx); id != "" {
+		r.Header.Set(RequestIDHeader, id)
+	}
+}
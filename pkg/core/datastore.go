@@ -17,6 +17,13 @@ type Datastore struct {
 	subscribers    map[string][]chan Message
 	mutex          sync.Mutex
 	hasIndexOnDisk bool
+
+	// disk holds only the values persisted to the base config file,
+	// so layered values aren't written back into it
+	disk *viper.Viper
+	// loaded is every config source as last read, so a reload can tell
+	// edits on disk apart from values changed at runtime
+	loaded *viper.Viper
 }
 
 // NewDatastore creates a new datastore with default values
@@ -42,9 +49,44 @@ func (ds *Datastore) Subscribe(topic string, ch chan Message) {
 	ds.subscribers[formattedTopic] = append(ds.subscribers[formattedTopic], ch)
 }
 
+// Unsubscribe removes the given channel from a topic's listeners
+func (ds *Datastore) Unsubscribe(topic string, ch chan Message) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	formattedTopic := strings.ToLower(topic)
+	listeners := ds.subscribers[formattedTopic]
+	for i, listener := range listeners {
+		if listener == ch {
+			// Copy rather than modify in place, publishers may be ranging over the old slice
+			remaining := make([]chan Message, 0, len(listeners)-1)
+			remaining = append(remaining, listeners[:i]...)
+			ds.subscribers[formattedTopic] = append(remaining, listeners[i+1:]...)
+			return
+		}
+	}
+}
+
 // Publish a given message to all subscribed entities
 // Topic is expected to be compatible with a Viper selector
 func (ds *Datastore) Publish(topic string, m interface{}) {
+	ds.publish(topic, m, ds.hasIndexOnDisk)
+}
+
+// PublishEvent notifies subscribers even when the value hasn't changed,
+// for momentary events like button presses
+func (ds *Datastore) PublishEvent(topic string, m interface{}) {
+	subscribers := ds.subscribers
+
+	ds.Store.Set(topic, m)
+	ds.Stats.Set(fmt.Sprintf("%s.write_date", topic), time.Now())
+	ds.Stats.Set(fmt.Sprintf("%s.writes", topic), ds.Stats.GetInt(fmt.Sprintf("%s.writes", topic))+1)
+
+	go publishToSubscribers(subscribers, topic, m)
+}
+
+// publish a message, optionally writing it to disk
+func (ds *Datastore) publish(topic string, m interface{}, persist bool) {
 	itemExists := ds.Store.IsSet(topic)
 	oldItem := ds.Store.Get(topic)
 	subscribers := ds.subscribers
@@ -55,9 +97,8 @@ func (ds *Datastore) Publish(topic string, m interface{}) {
 
 	// write to disk if configured
 	if ds.hasIndexOnDisk {
-		err := ds.Store.WriteConfig()
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to write viper config file")
+		if persist {
+			ds.writeToDisk(topic, m)
 		}
 
 	} else if itemExists {
@@ -90,6 +131,41 @@ func (ds *Datastore) Publish(topic string, m interface{}) {
 	go publishToSubscribers(subscribers, topic, m)
 }
 
+// Matches determines if every key currently holds its given value, comparing them as text.
+// Keys that aren't set never match
+func (ds *Datastore) Matches(conditions map[string]interface{}) bool {
+	for key, expected := range conditions {
+		if !ds.Store.IsSet(key) {
+			return false
+		}
+		if !strings.EqualFold(fmt.Sprintf("%v", ds.Store.Get(key)), fmt.Sprintf("%v", expected)) {
+			return false
+		}
+	}
+	return true
+}
+
+// writeToDisk persists a single value into the base config file
+func (ds *Datastore) writeToDisk(topic string, m interface{}) {
+	setSource(topic, runtimeSource)
+
+	// The config watcher swaps the disk layer on reload
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	disk := ds.disk
+	if disk == nil {
+		disk = ds.Store
+	} else {
+		disk.Set(topic, m)
+	}
+
+	err := disk.WriteConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to write viper config file")
+	}
+}
+
 func publishToSubscribers(subscribers map[string][]chan Message, topic string, m interface{}) {
 	for _, ch := range subscribers[strings.ToLower(topic)] {
 		select {
@@ -4,8 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-
-	"github.com/rs/zerolog/log"
 )
 
 // JSONResponse for common return value to API
@@ -42,7 +40,7 @@ func (response *JSONResponse) Write(w *http.ResponseWriter, r *http.Request) {
 	}
 
 	// Log this to debug
-	log.Debug().
+	Log(r.Context()).Debug().
 		Str("Path", r.URL.Path).
 		Str("Method", r.Method).
 		Str("Output", fmt.Sprintf("%v", response.Output)).
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+const redacted = "********"
+
+// sensitiveNames are always treated as secrets, even when given in plain text
+var sensitiveNames = []string{"password", "token", "secret", "api_key"}
+
+var (
+	secretKeys     map[string]bool
+	secretKeysLock sync.Mutex
+)
+
+// resolveSecrets walks the given settings, replacing `file:` and `env:` references
+// with the value they point to. Returns the resolved settings and every key that held a reference
+func resolveSecrets(settings map[string]interface{}) (map[string]interface{}, map[string]bool) {
+	secrets := make(map[string]bool)
+	resolved := resolveValue("", settings, secrets)
+	return resolved.(map[string]interface{}), secrets
+}
+
+func resolveValue(key string, value interface{}, secrets map[string]bool) interface{} {
+	switch vv := value.(type) {
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(vv))
+		for k, v := range vv {
+			resolved[k] = resolveValue(joinKey(key, k), v, secrets)
+		}
+		return resolved
+	case map[interface{}]interface{}:
+		resolved := make(map[string]interface{}, len(vv))
+		for k, v := range vv {
+			name := fmt.Sprintf("%v", k)
+			resolved[name] = resolveValue(joinKey(key, name), v, secrets)
+		}
+		return resolved
+	case []interface{}:
+		resolved := make([]interface{}, len(vv))
+		for i, v := range vv {
+			resolved[i] = resolveValue(joinKey(key, fmt.Sprintf("%d", i)), v, secrets)
+		}
+		return resolved
+	case string:
+		secret, isReference, err := resolveReference(vv)
+		if !isReference {
+			return vv
+		}
+		secrets[key] = true
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to resolve secret for setting %s", key)
+		}
+		return secret
+	}
+	return value
+}
+
+// resolveReference reads the secret a `file:` or `env:` string points to
+func resolveReference(value string) (string, bool, error) {
+	switch {
+	case strings.HasPrefix(value, "file:"):
+		contents, err := ioutil.ReadFile(strings.TrimPrefix(value, "file:"))
+		if err != nil {
+			return "", true, err
+		}
+		return strings.TrimRight(string(contents), "\r\n"), true, nil
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimPrefix(value, "env:")
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", true, fmt.Errorf("environment variable %s is not set", name)
+		}
+		return secret, true, nil
+	}
+	return value, false, nil
+}
+
+func joinKey(prefix string, key string) string {
+	if prefix == "" {
+		return strings.ToLower(key)
+	}
+	return prefix + "." + strings.ToLower(key)
+}
+
+func setSecretKeys(keys map[string]bool) {
+	secretKeysLock.Lock()
+	secretKeys = keys
+	secretKeysLock.Unlock()
+}
+
+// IsSecret determines if a settings key holds a credential that shouldn't be shown
+func IsSecret(key string) bool {
+	key = strings.ToLower(key)
+
+	secretKeysLock.Lock()
+	isReference := secretKeys[key]
+	secretKeysLock.Unlock()
+	if isReference {
+		return true
+	}
+
+	name := key[strings.LastIndex(key, ".")+1:]
+	for _, sensitive := range sensitiveNames {
+		if name == sensitive {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact returns a copy of a settings value with any secrets under the given key masked
+func Redact(key string, value interface{}) interface{} {
+	switch vv := value.(type) {
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(vv))
+		for k, v := range vv {
+			masked[k] = Redact(joinKey(key, k), v)
+		}
+		return masked
+	case map[interface{}]interface{}:
+		masked := make(map[string]interface{}, len(vv))
+		for k, v := range vv {
+			name := fmt.Sprintf("%v", k)
+			masked[name] = Redact(joinKey(key, name), v)
+		}
+		return masked
+	case []interface{}:
+		masked := make([]interface{}, len(vv))
+		for i, v := range vv {
+			masked[i] = Redact(joinKey(key, fmt.Sprintf("%d", i)), v)
+		}
+		return masked
+	}
+
+	if IsSecret(key) {
+		return redacted
+	}
+	return value
+}
+
+// RedactedSettings returns all settings, safe to be logged or served
+func RedactedSettings() map[string]interface{} {
+	return Redact("", Settings.Store.AllSettings()).(map[string]interface{})
+}
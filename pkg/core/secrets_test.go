@@ -0,0 +1,117 @@
+package core
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	dir := useConfigDir(t, nil)
+	tokenFile := filepath.Join(dir, "token")
+	writeFile(t, tokenFile, "hunter2\n")
+	t.Setenv("SECRETS_TEST_PASSWORD", "correct horse")
+
+	settings := map[string]interface{}{
+		"mdroid": map[string]interface{}{
+			"token": "file:" + tokenFile,
+			"debug": true,
+		},
+		"mqtt": map[interface{}]interface{}{
+			"connections": []interface{}{
+				map[interface{}]interface{}{"address": "tcp://localhost:1883", "Password": "env:SECRETS_TEST_PASSWORD"},
+			},
+		},
+		"bluetooth": map[string]interface{}{
+			"address": "env:SECRETS_TEST_UNSET",
+			"name":    "profile:car",
+		},
+	}
+
+	resolved, secrets := resolveSecrets(settings)
+	want := map[string]interface{}{
+		"mdroid": map[string]interface{}{
+			"token": "hunter2",
+			"debug": true,
+		},
+		"mqtt": map[string]interface{}{
+			"connections": []interface{}{
+				map[string]interface{}{"address": "tcp://localhost:1883", "Password": "correct horse"},
+			},
+		},
+		"bluetooth": map[string]interface{}{
+			// References that can't be resolved are left empty rather than used as a value
+			"address": "",
+			"name":    "profile:car",
+		},
+	}
+	if !reflect.DeepEqual(resolved, want) {
+		t.Errorf("resolveSecrets() = %v, want %v", resolved, want)
+	}
+
+	wantSecrets := map[string]bool{
+		"mdroid.token":                true,
+		"mqtt.connections.0.password": true,
+		"bluetooth.address":           true,
+	}
+	if !reflect.DeepEqual(secrets, wantSecrets) {
+		t.Errorf("resolveSecrets() found secrets %v, want %v", secrets, wantSecrets)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	setSecretKeys(map[string]bool{"mqtt.connections.0.password": true, "bluetooth.address": true})
+	t.Cleanup(func() { setSecretKeys(nil) })
+
+	settings := map[string]interface{}{
+		"mdroid": map[string]interface{}{"token": "hunter2", "debug": true},
+		"mqtt": map[interface{}]interface{}{
+			"connections": []interface{}{
+				map[interface{}]interface{}{"address": "tcp://localhost:1883", "password": "correct horse"},
+				map[interface{}]interface{}{"address": "tcp://remote:1883"},
+			},
+		},
+		"bluetooth": map[string]interface{}{"address": "AA:BB:CC:DD:EE:FF", "name": "car"},
+	}
+	want := map[string]interface{}{
+		"mdroid": map[string]interface{}{"token": redacted, "debug": true},
+		"mqtt": map[string]interface{}{
+			"connections": []interface{}{
+				map[string]interface{}{"address": "tcp://localhost:1883", "password": redacted},
+				map[string]interface{}{"address": "tcp://remote:1883"},
+			},
+		},
+		"bluetooth": map[string]interface{}{"address": redacted, "name": "car"},
+	}
+
+	if got := Redact("", settings); !reflect.DeepEqual(got, want) {
+		t.Errorf("Redact() = %v, want %v", got, want)
+	}
+	if got := Redact("kbus.device", "/dev/ttyUSB0"); got != "/dev/ttyUSB0" {
+		t.Errorf("Redact() = %v, want the value unchanged", got)
+	}
+	if got := Redact("can.api_key", "abc123"); got != redacted {
+		t.Errorf("Redact() = %v, want %s", got, redacted)
+	}
+}
+
+func TestLoadConfigSecrets(t *testing.T) {
+	dir := useConfigDir(t, map[string]string{
+		configName: "mdroid:\n  token: env:SECRETS_TEST_TOKEN\nkbus:\n  device: /dev/ttyUSB0\n",
+	})
+	t.Setenv("SECRETS_TEST_TOKEN", "hunter2")
+
+	layers, err := loadConfig()
+	if err != nil {
+		t.Fatalf("failed to load config: %s", err.Error())
+	}
+	if got := layers.merged.GetString("mdroid.token"); got != "hunter2" {
+		t.Errorf("mdroid.token = %s, want the resolved secret", got)
+	}
+	if got := layers.base.GetString("mdroid.token"); got != "env:SECRETS_TEST_TOKEN" {
+		t.Errorf("base mdroid.token = %s, want the reference kept so it isn't written to %s", got, filepath.Join(dir, configName))
+	}
+	if !layers.secrets["mdroid.token"] || layers.secrets["kbus.device"] {
+		t.Errorf("secrets = %v, want only mdroid.token", layers.secrets)
+	}
+}
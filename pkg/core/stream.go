@@ -0,0 +1,42 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// EventStream writes server-sent events to a long lived HTTP response
+type EventStream struct {
+	writer     http.ResponseWriter
+	controller *http.ResponseController
+}
+
+// NewEventStream prepares the response for streaming, lifting the server's write timeout
+func NewEventStream(w http.ResponseWriter) (*EventStream, error) {
+	controller := http.NewResponseController(w)
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil {
+		return nil, fmt.Errorf("Failed to lift write deadline: %s", err.Error())
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	stream := &EventStream{writer: w, controller: controller}
+	return stream, controller.Flush()
+}
+
+// Send a named event, encoding data as JSON
+func (s *EventStream) Send(event string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.writer, "event: %s\ndata: %s\n\n", event, encoded); err != nil {
+		return err
+	}
+	return s.controller.Flush()
+}
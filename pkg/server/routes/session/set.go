@@ -9,7 +9,6 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/qcasey/mdroid/pkg/core"
-	"github.com/rs/zerolog/log"
 )
 
 // Package holds the Package and last update info for each session value
@@ -30,7 +29,7 @@ func Set() http.HandlerFunc {
 		response := core.JSONResponse{OK: false}
 
 		if err != nil {
-			log.Error().Msgf("Error reading body: %v", err)
+			core.Log(r.Context()).Error().Msgf("Error reading body: %v", err)
 			http.Error(w, "can't read body", http.StatusBadRequest)
 			return
 		}
@@ -49,7 +48,7 @@ func Set() http.HandlerFunc {
 		var newdata Package
 
 		if err = json.NewDecoder(r.Body).Decode(&newdata); err != nil {
-			log.Error().Err(err).Msg("Error decoding incoming JSON")
+			core.Log(r.Context()).Error().Err(err).Msg("Error decoding incoming JSON")
 			response.Output = err.Error()
 			response.Write(&w, r)
 			return
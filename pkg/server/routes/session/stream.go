@@ -0,0 +1,54 @@
+package session
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/qcasey/mdroid/pkg/core"
+)
+
+// Update is a single streamed session change
+type Update struct {
+	Topic string      `json:"topic"`
+	Value interface{} `json:"value"`
+	Time  time.Time   `json:"time"`
+}
+
+// Stream sends every session update as a server-sent event, optionally filtered by ?topic=
+func Stream() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stream, err := core.NewEventStream(w)
+		if err != nil {
+			core.Log(r.Context()).Error().Err(err).Msg("Failed to open session stream")
+			core.WriteNewResponse(&w, r, core.JSONResponse{Output: err.Error(), OK: false})
+			return
+		}
+
+		topics := make(map[string]bool)
+		for _, topic := range r.URL.Query()["topic"] {
+			topics[strings.ToLower(topic)] = true
+		}
+
+		updates := make(chan core.Message, 10)
+		core.Session.Subscribe("*", updates)
+		defer core.Session.Unsubscribe("*", updates)
+
+		core.Log(r.Context()).Info().Msgf("Streaming session to %s", r.RemoteAddr)
+		for {
+			select {
+			case <-r.Context().Done():
+				core.Log(r.Context()).Info().Msgf("Closed session stream to %s", r.RemoteAddr)
+				return
+			case m := <-updates:
+				if len(topics) > 0 && !topics[strings.ToLower(m.Topic)] {
+					continue
+				}
+				if err := stream.Send("session", Update{Topic: m.Topic, Value: m.Value, Time: time.Now()}); err != nil {
+					core.Log(r.Context()).Error().Err(err).Msg("Failed to write session stream")
+					return
+				}
+			}
+		}
+	}
+}
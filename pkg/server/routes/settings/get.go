@@ -5,15 +5,13 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/qcasey/mdroid/pkg/core"
-	"github.com/rs/zerolog/log"
 )
 
 // GetAll returns all current settings
 func GetAll() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Debug().Msg("Responding to GET request with entire settings map.")
-		returnMap := make(map[string]string)
-		resp := core.JSONResponse{Output: core.Settings.Store.Unmarshal(returnMap), Status: "success", OK: true}
+		core.Log(r.Context()).Debug().Msg("Responding to GET request with entire settings map.")
+		resp := core.JSONResponse{Output: core.RedactedSettings(), Status: "success", OK: true}
 		resp.Write(&w, r)
 	}
 }
@@ -24,9 +22,9 @@ func Get() http.HandlerFunc {
 		params := mux.Vars(r)
 		componentName := core.FormatName(params["key"])
 
-		log.Debug().Msgf("Responding to GET request for setting component %s", componentName)
+		core.Log(r.Context()).Debug().Msgf("Responding to GET request for setting component %s", componentName)
 
-		resp := core.JSONResponse{Output: core.Settings.Store.Get(params["key"]), OK: true}
+		resp := core.JSONResponse{Output: core.Redact(params["key"], core.Settings.Store.Get(params["key"])), OK: true}
 		if !core.Settings.Store.IsSet(params["key"]) {
 			resp = core.JSONResponse{Output: "Setting not found.", OK: false}
 		}
@@ -34,3 +32,12 @@ func Get() http.HandlerFunc {
 		resp.Write(&w, r)
 	}
 }
+
+// Sources returns where each effective setting value came from
+func Sources() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		core.Log(r.Context()).Debug().Msg("Responding to GET request for setting sources.")
+		resp := core.JSONResponse{Output: core.SettingSources(), OK: true}
+		resp.Write(&w, r)
+	}
+}
@@ -5,7 +5,6 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/qcasey/mdroid/pkg/core"
-	"github.com/rs/zerolog/log"
 )
 
 // Set is the http wrapper for our setting setter
@@ -18,7 +17,7 @@ func Set() http.HandlerFunc {
 		value := params["value"]
 
 		// Log if requested
-		log.Debug().Msgf("Responding to POST request for setting %s to be value %s", key, value)
+		core.Log(r.Context()).Debug().Msgf("Responding to POST request for setting %s to be value %v", key, core.Redact(key, value))
 
 		// Do the dirty work elsewhere
 		core.Settings.Publish(key, value)
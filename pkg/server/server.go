@@ -2,7 +2,9 @@ package server
 
 import (
 	"fmt"
+	"net"
 	"net/http"
+	"os"
 	"strings"
 	"sync"
 	"time"
@@ -88,6 +90,11 @@ func (srv *Server) Start() {
 		ReadTimeout:  20 * time.Second,
 	}
 
+	// Optionally serve local clients over a unix socket
+	if socketPath := core.Settings.Store.GetString("mdroid.socket"); socketPath != "" {
+		go serveUnixSocket(httpServer, socketPath)
+	}
+
 	log.Info().Msg("Starting server...")
 
 	// Start the router in an endless loop
@@ -98,16 +105,70 @@ func (srv *Server) Start() {
 	}
 }
 
+// serveUnixSocket shares the HTTP server with clients on the given socket path
+func serveUnixSocket(httpServer *http.Server, socketPath string) {
+	for {
+		// Remove any stale socket left behind by a previous run
+		if err := os.RemoveAll(socketPath); err != nil {
+			log.Error().Err(err).Msgf("Failed to remove old socket %s", socketPath)
+		}
+
+		listener, err := net.Listen("unix", socketPath)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to listen on socket %s. Retrying in 10 seconds...", socketPath)
+			time.Sleep(time.Second * 10)
+			continue
+		}
+
+		log.Info().Msgf("Serving on socket %s", socketPath)
+		err = httpServer.Serve(listener)
+		log.Error().Err(err).Msg("Socket server failed unexpectedly. Restarting in 10 seconds...")
+		time.Sleep(time.Second * 10)
+	}
+}
+
+// correlate tags each request with a correlation ID, reusing the caller's if given
+func correlate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(core.RequestIDHeader)
+		if id == "" {
+			id = core.NewRequestID()
+		}
+		w.Header().Set(core.RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(core.WithRequestID(r.Context(), id)))
+	})
+}
+
+// authenticate rejects requests without the configured API token
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !core.IsAuthorized(r) {
+			core.Log(r.Context()).Warn().Msgf("Rejected unauthorized request to %s from %s", r.URL.Path, r.RemoteAddr)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (srv *Server) injectRoutes() {
+	srv.Router.Use(correlate, authenticate)
+
 	//
 	// Debug route
 	//
 	srv.Router.HandleFunc("/debug/level/{level}", handleChangeLogLevel).Methods("GET")
 
+	//
+	// Health route
+	//
+	srv.Router.HandleFunc("/health", handleHealth).Methods("GET")
+
 	//
 	// Session routes
 	//
 	srv.Router.HandleFunc("/session", session.GetAll()).Methods("GET")
+	srv.Router.HandleFunc("/session/stream", session.Stream()).Methods("GET")
 	srv.Router.HandleFunc("/session/{name}", session.Get()).Methods("GET")
 	srv.Router.HandleFunc("/session/{name}", session.Set()).Methods("POST")
 
@@ -115,6 +176,7 @@ func (srv *Server) injectRoutes() {
 	// Settings routes
 	//
 	srv.Router.HandleFunc("/settings", settings.GetAll()).Methods("GET")
+	srv.Router.HandleFunc("/settings/sources", settings.Sources()).Methods("GET")
 	srv.Router.HandleFunc("/settings/{key}", settings.Get()).Methods("GET")
 	srv.Router.HandleFunc("/settings/{key}/{value}", settings.Set()).Methods("POST")
 
@@ -141,3 +203,11 @@ func handleChangeLogLevel(w http.ResponseWriter, r *http.Request) {
 	}
 	core.WriteNewResponse(&w, r, core.JSONResponse{Output: level, OK: true})
 }
+
+// handleHealth reports that the server is up, and for how long
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	core.WriteNewResponse(&w, r, core.JSONResponse{Output: map[string]interface{}{
+		"started": core.StartTime,
+		"uptime":  time.Since(core.StartTime).Round(time.Second).String(),
+	}, OK: true})
+}